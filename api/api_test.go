@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthentication(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		api.do("GET", "/api/salons", "", nil).expect(t, http.StatusUnauthorized)
+		api.do("GET", "/api/salons", "not-a-token", nil).expect(t, http.StatusUnauthorized)
+
+		client := api.account(RoleClient, 0, 0)
+		r := api.do("POST", "/api/auth/login", "", Credentials{Email: client.Email, Password: "wrong password"}).expect(t, http.StatusUnauthorized)
+		if code := r.problem(t); code != "invalid_credentials" {
+			t.Errorf("code %q, want invalid_credentials", code)
+		}
+
+		api.do("GET", "/api/salons", client.token, nil).expect(t, http.StatusOK)
+		api.do("POST", "/api/auth/logout", client.token, nil).expect(t, http.StatusNoContent)
+		api.do("GET", "/api/salons", client.token, nil).expect(t, http.StatusUnauthorized)
+	})
+}
+
+func TestSignupHidesPassword(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		r := api.do("POST", "/api/clients/add", "", `{"firstname":"Léa","lastname":"Petit","email":"lea@example.com","password":"secret-password"}`).
+			expect(t, http.StatusCreated)
+		if strings.Contains(string(r.Body), "password") {
+			t.Errorf("password in response: %s", r.Body)
+		}
+
+		var session Session
+		api.do("POST", "/api/auth/login", "", Credentials{Email: "lea@example.com", Password: "secret-password"}).
+			expect(t, http.StatusOK).decode(t, &session)
+		if session.Client == nil || session.Client.Role != RoleClient {
+			t.Errorf("session client %+v, want a plain client", session.Client)
+		}
+	})
+}
+
+func TestEmailsAreUnique(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		signup := `{"firstname":"Léa","lastname":"Petit","email":"lea@example.com","password":"secret-password"}`
+		api.do("POST", "/api/clients/add", "", signup).expect(t, http.StatusCreated)
+		if code := api.do("POST", "/api/clients/add", "", signup).expect(t, http.StatusConflict).problem(t); code != "email_taken" {
+			t.Errorf("code %q, want email_taken", code)
+		}
+
+		other := api.account(RoleClient, 0, 0)
+		url := pathf("/api/v2/clients/%s", other.ID_client)
+		r := api.do("PATCH", url, api.admin, `{"email":"lea@example.com"}`, "If-Match", "*").expect(t, http.StatusConflict)
+		if code := r.problem(t); code != "email_taken" {
+			t.Errorf("code %q, want email_taken", code)
+		}
+		// Keeping one's own email is no conflict.
+		api.do("PATCH", url, api.admin, `{"firstname":"Noé"}`, "If-Match", "*").expect(t, http.StatusOK)
+	})
+}
+
+func TestSalonLifecycle(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		url := pathf("/api/v2/salons/%s", salon.ID_salon)
+
+		var got Salon
+		r := api.do("GET", url, api.admin, nil).expect(t, http.StatusOK)
+		r.decode(t, &got)
+		if got != salon {
+			t.Errorf("got %+v, want %+v", got, salon)
+		}
+
+		var updated Salon
+		api.do("PATCH", url, api.admin, `{"name":"Renamed"}`, "If-Match", r.Header.Get("ETag")).
+			expect(t, http.StatusOK).decode(t, &updated)
+		if updated.Name != "Renamed" || updated.Timezone != "Europe/Paris" || updated.Version != 2 {
+			t.Errorf("updated %+v", updated)
+		}
+
+		var page []Salon
+		api.do("GET", "/api/v2/salons", api.admin, nil).expect(t, http.StatusOK).decode(t, &page)
+		if len(page) != 1 || page[0].Name != "Renamed" {
+			t.Errorf("listed %+v", page)
+		}
+
+		api.do("DELETE", url, api.admin, nil, "If-Match", etag(updated.Version)).expect(t, http.StatusOK)
+		api.do("GET", url, api.admin, nil).expect(t, http.StatusNotFound)
+	})
+}
+
+func TestValidation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		r := api.do("POST", "/api/v2/salons", api.admin, Salon{Timezone: "Mars/Olympus"}).expect(t, http.StatusBadRequest)
+		var problem Problem
+		r.decode(t, &problem)
+		if problem.Code != "validation_failed" || len(problem.Errors) != 2 {
+			t.Errorf("problem %+v, want name and timezone errors", problem)
+		}
+
+		api.do("POST", "/api/v2/salons", api.admin, `{"name":`).expect(t, http.StatusBadRequest)
+		api.do("POST", "/api/v2/coiffeurs", api.admin, Coiffeur{ID_salon: 999, Firstname: "A", Lastname: "B"}).
+			expect(t, http.StatusBadRequest)
+
+		coiffeur := api.coiffeur(api.salon().ID_salon)
+		r = api.do("POST", "/api/v2/creneaux", api.admin, Creneau{ID_coiffeur: coiffeur.ID_coiffeur, Start: testStart(), End: testStart().Add(-time.Hour)}).
+			expect(t, http.StatusBadRequest)
+		problem = Problem{}
+		r.decode(t, &problem)
+		if len(problem.Errors) != 1 || problem.Errors[0].Field != "end" {
+			t.Errorf("problem %+v, want an end error", problem)
+		}
+	})
+}
+
+func TestRoutes(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		r := api.do("POST", "/api/v2/salons/1", api.admin, nil).expect(t, http.StatusMethodNotAllowed)
+		if allow := r.Header.Get("Allow"); allow != "DELETE, GET, PATCH, PUT" {
+			t.Errorf("Allow %q", allow)
+		}
+		api.do("GET", "/api/v2/salons/abc", api.admin, nil).expect(t, http.StatusNotFound)
+		api.do("GET", "/api/nowhere", api.admin, nil).expect(t, http.StatusNotFound)
+
+		// The v1 routes stay in place next to the v2 ones.
+		api.do("GET", "/api/salons", api.admin, nil).expect(t, http.StatusOK)
+	})
+}
+
+func TestEmptyListsAreArrays(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		coiffeur := api.coiffeur(salon.ID_salon)
+		for _, path := range []string{
+			pathf("/api/v2/salons/%s/services", salon.ID_salon),
+			pathf("/api/v2/coiffeurs/%s/horaires", coiffeur.ID_coiffeur),
+			"/api/v2/services",
+			"/api/v2/horaires",
+		} {
+			r := api.do("GET", path, api.admin, nil).expect(t, http.StatusOK)
+			if body := strings.TrimSpace(string(r.Body)); body != "[]" {
+				t.Errorf("GET %s: %s, want []", path, body)
+			}
+		}
+	})
+}
+
+func TestBooking(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+		if reservation.Duration != 30 || reservation.Price != 25 {
+			t.Errorf("reservation %+v does not carry the service", reservation)
+		}
+
+		other := api.account(RoleClient, 0, 0)
+		r := api.do("POST", "/api/reservations/add", other.token, b.reservation(other.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusConflict)
+		if code := r.problem(t); code != "creneau_unavailable" {
+			t.Errorf("code %q, want creneau_unavailable", code)
+		}
+
+		api.do("GET", pathf("/api/v2/reservations/%s", reservation.ID_reservation), client.token, nil).expect(t, http.StatusOK)
+		api.do("GET", pathf("/api/v2/reservations/%s", reservation.ID_reservation), other.token, nil).expect(t, http.StatusForbidden)
+	})
+}
+
+func TestV1WritesRequireIfMatch(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		update := pathf("/api/salons/update?id_salon=%s", salon.ID_salon)
+		body := fmt.Sprintf(`{"id_salon":%d,"name":"Renamed","timezone":"Europe/Paris"}`, salon.ID_salon)
+
+		r := api.do("PUT", update, api.admin, body).expect(t, http.StatusPreconditionRequired)
+		if code := r.problem(t); code != "precondition_required" {
+			t.Errorf("code %q, want precondition_required", code)
+		}
+		api.do("DELETE", pathf("/api/salons/delete?id_salon=%s", salon.ID_salon), api.admin, nil).
+			expect(t, http.StatusPreconditionRequired)
+
+		api.do("PUT", update, api.admin, body, "If-Match", etag(salon.Version+1)).expect(t, http.StatusPreconditionFailed)
+		api.do("PUT", update, api.admin, body, "If-Match", etag(salon.Version)).expect(t, http.StatusOK)
+	})
+}
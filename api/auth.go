@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// MarshalJSON leaves the password out of every response: the shadowing field
+// is always empty, so omitempty drops it.
+func (c Client) MarshalJSON() ([]byte, error) {
+	type client Client
+	return json.Marshal(struct {
+		client
+		Password string `json:"password,omitempty"`
+	}{client: client(c)})
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash tells bcrypt hashes apart from the plaintext passwords
+// stored before hashing was introduced.
+func isPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword compares a password with the stored value. rehash is true when
+// the stored value is a legacy plaintext password that matched.
+func checkPassword(stored, password string) (ok bool, rehash bool) {
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return ok, ok
+}
+
+// dummyPasswordHash is checked against when no account has the email, so
+// that an unknown email takes as long to refuse as a wrong password and does
+// not reveal which emails have an account. Its cost is the one of
+// hashPassword.
+const dummyPasswordHash = "$2a$10$xURdCCGc5D/wc672IM/Sqea2uG.oVka2CjDEEayxLZnbMV1KdBYwK"
+
+// AUTH
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var credentials Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	var fieldErrors []FieldError
+	if credentials.Email == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "email", Code: "required", Message: "is required"})
+	}
+	if credentials.Password == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "password", Code: "required", Message: "is required"})
+	}
+	if len(fieldErrors) > 0 {
+		invalidFields(w, r, fieldErrors...)
+		return
+	}
+
+	client, err := s.clients.GetClientByEmail(r.Context(), credentials.Email)
+	if err != nil {
+		if err == errNotFound {
+			checkPassword(dummyPasswordHash, credentials.Password)
+			writeProblem(w, r, http.StatusUnauthorized, "invalid_credentials", "wrong email or password")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+
+	ok, rehash := checkPassword(client.Password, credentials.Password)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "invalid_credentials", "wrong email or password")
+		return
+	}
+
+	if rehash {
+		hash, err := hashPassword(credentials.Password)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		err = s.clients.SetClientPassword(r.Context(), client.ID_client, hash)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+	}
+
+	session, err := s.createSession(r.Context(), client.ID_client)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	session.Client = &client
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
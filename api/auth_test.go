@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginRehashesPlaintextPasswords(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		ctx := context.Background()
+		legacy := Client{Firstname: "Léa", Lastname: "Petit", Email: "lea@example.com", Password: "legacy-password"}
+		if err := api.store.AddClient(ctx, &legacy); err != nil {
+			t.Fatal(err)
+		}
+
+		api.do("POST", "/api/auth/login", "", Credentials{Email: legacy.Email, Password: "wrong password"}).expect(t, http.StatusUnauthorized)
+		if stored, _ := api.store.GetClientByEmail(ctx, legacy.Email); stored.Password != "legacy-password" {
+			t.Fatalf("password rewritten after a failed login: %q", stored.Password)
+		}
+
+		api.do("POST", "/api/auth/login", "", Credentials{Email: legacy.Email, Password: "legacy-password"}).expect(t, http.StatusOK)
+		stored, err := api.store.GetClientByEmail(ctx, legacy.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isPasswordHash(stored.Password) || bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("legacy-password")) != nil {
+			t.Fatalf("password %q not rehashed", stored.Password)
+		}
+		api.do("POST", "/api/auth/login", "", Credentials{Email: legacy.Email, Password: "legacy-password"}).expect(t, http.StatusOK)
+	})
+}
+
+// An unknown email is refused after a bcrypt comparison as costly as the one
+// of a real account.
+func TestDummyPasswordHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost %d, want the cost of hashPassword: %d", cost, bcrypt.DefaultCost)
+	}
+	if ok, _ := checkPassword(dummyPasswordHash, ""); ok {
+		t.Error("dummy hash matches the empty password")
+	}
+
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		r := api.do("POST", "/api/auth/login", "", Credentials{Email: "nobody@example.com", Password: testPassword}).expect(t, http.StatusUnauthorized)
+		if code := r.problem(t); code != "invalid_credentials" {
+			t.Errorf("code %q, want invalid_credentials", code)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	dayLayout            = "2006-01-02"
+	defaultAvailableDays = 7
+	maxAvailableDays     = 62
+)
+
+// AvailableSlot is a bookable start time in the salon's time zone; End is when
+// the service would finish.
+type AvailableSlot struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	ID_creneau int       `json:"id_creneau"`
+}
+
+type CoiffeurAvailability struct {
+	ID_coiffeur int             `json:"id_coiffeur"`
+	Firstname   string          `json:"firstname"`
+	Lastname    string          `json:"lastname"`
+	Slots       []AvailableSlot `json:"slots"`
+}
+
+// AVAILABILITY
+// getAvailabilityHandler answers
+//
+//	GET /api/availability?id_salon=&id_service=[&id_coiffeur=][&from=YYYY-MM-DD][&to=YYYY-MM-DD]
+//
+// with the start times, grouped by coiffeur, at which the service can be
+// booked: the coiffeur performs it and free creneaux cover its whole duration
+// back to back. Days are read in the salon's time zone; the range is inclusive
+// and defaults to the next 7 days.
+func (s *server) getAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	idSalon, err := strconv.Atoi(query.Get("id_salon"))
+	if err != nil {
+		invalidParam(w, r, "id_salon")
+		return
+	}
+	idService, err := strconv.Atoi(query.Get("id_service"))
+	if err != nil {
+		invalidParam(w, r, "id_service")
+		return
+	}
+	idCoiffeur := 0
+	if idParam := query.Get("id_coiffeur"); idParam != "" {
+		idCoiffeur, err = strconv.Atoi(idParam)
+		if err != nil {
+			invalidParam(w, r, "id_coiffeur")
+			return
+		}
+	}
+
+	salon, err := s.salons.GetSalon(r.Context(), idSalon)
+	if err != nil {
+		if err == errNotFound {
+			resourceNotFound(w, r, "salon not found")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+	loc := salonLocation(salon.Timezone)
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if value := query.Get("from"); value != "" {
+		from, err = time.ParseInLocation(dayLayout, value, loc)
+		if err != nil {
+			invalidParam(w, r, "from")
+			return
+		}
+	}
+	to := from.AddDate(0, 0, defaultAvailableDays)
+	if value := query.Get("to"); value != "" {
+		to, err = time.ParseInLocation(dayLayout, value, loc)
+		if err != nil {
+			invalidParam(w, r, "to")
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) || to.Sub(from) > maxAvailableDays*24*time.Hour {
+		badRequest(w, r, "invalid_range", fmt.Sprintf("from must not be after to, and the range cannot exceed %d days", maxAvailableDays))
+		return
+	}
+
+	service, err := s.services.GetService(r.Context(), idService)
+	if err != nil {
+		if err == errNotFound {
+			resourceNotFound(w, r, "service not found")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+	if service.ID_salon != idSalon {
+		badRequest(w, r, "service_not_offered", errServiceNotOffered.Error())
+		return
+	}
+
+	// Slots that already started cannot be booked.
+	if from.Before(now) {
+		from = now
+	}
+
+	availability, err := s.findAvailability(r.Context(), idSalon, idService, idCoiffeur, time.Duration(service.Duration)*time.Minute, from, to)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(availability)
+}
+
+func (s *server) findAvailability(ctx context.Context, idSalon, idService, idCoiffeur int, duration time.Duration, from, to time.Time) ([]CoiffeurAvailability, error) {
+	coiffeurList, err := s.coiffeurs.ListCoiffeurs(ctx, CoiffeurFilter{ID_salon: idSalon, ID_service: idService}, ListOptions{Sort: "id"})
+	if err != nil {
+		return nil, err
+	}
+
+	availability := []CoiffeurAvailability{}
+	for _, coiffeur := range coiffeurList.Items {
+		if idCoiffeur != 0 && coiffeur.ID_coiffeur != idCoiffeur {
+			continue
+		}
+
+		// Creneaux starting before the end of the range may extend past it,
+		// so fetch those that could complete a booking started inside it.
+		creneaux, err := s.creneaux.FreeCreneaux(ctx, coiffeur.ID_coiffeur, from, to.Add(duration))
+		if err != nil {
+			return nil, err
+		}
+		availability = append(availability, CoiffeurAvailability{
+			ID_coiffeur: coiffeur.ID_coiffeur,
+			Firstname:   coiffeur.Firstname,
+			Lastname:    coiffeur.Lastname,
+			Slots:       fittingSlots(creneaux, duration, from, to),
+		})
+	}
+	return availability, nil
+}
+
+func performs(coiffeur Coiffeur, idService int) bool {
+	for _, id := range coiffeur.Services {
+		if id == idService {
+			return true
+		}
+	}
+	return false
+}
+
+// bookingSpan returns the creneaux a booking of duration starting with
+// creneaux[0] takes: that creneau and the ones following it without a gap
+// until duration is covered. creneaux must be in chronological order. ok is
+// false when a gap or the end of creneaux comes first; span then holds the
+// creneaux chained so far.
+func bookingSpan(creneaux []Creneau, duration time.Duration) (span []Creneau, ok bool) {
+	if len(creneaux) == 0 {
+		return nil, false
+	}
+	span = creneaux[:1]
+	end := creneaux[0].End
+	for end.Sub(creneaux[0].Start) < duration {
+		next := len(span)
+		if next == len(creneaux) || !creneaux[next].Start.Equal(end) {
+			return span, false
+		}
+		span = creneaux[:next+1]
+		end = creneaux[next].End
+	}
+	return span, true
+}
+
+// spanHolds tells whether the span of a booking of duration starting with
+// first took creneau. Legacy reservations without a duration hold their
+// first creneau only.
+func spanHolds(first Creneau, duration time.Duration, creneau Creneau) bool {
+	if creneau.ID_creneau == first.ID_creneau {
+		return true
+	}
+	return creneau.ID_coiffeur == first.ID_coiffeur && !creneau.Availability &&
+		!creneau.Start.Before(first.Start) && creneau.Start.Before(first.Start.Add(duration))
+}
+
+// checkSpan tells whether reservation can take span, the creneaux
+// bookingSpan chained for it.
+func checkSpan(span []Creneau, reservation Reservation) error {
+	if span[0].ID_coiffeur != reservation.ID_coiffeur {
+		return errCreneauOtherCoiffeur
+	}
+	for _, creneau := range span {
+		if !creneau.Availability {
+			return errCreneauUnavailable
+		}
+	}
+	if span[len(span)-1].End.Sub(span[0].Start) < time.Duration(reservation.Duration)*time.Minute {
+		return errCreneauTooShort
+	}
+	return nil
+}
+
+// fittingSlots keeps the creneaux starting in [from, to) whose booking span,
+// as BookReservation takes it, covers duration. Slots are returned in the
+// location of from.
+func fittingSlots(creneaux []Creneau, duration time.Duration, from, to time.Time) []AvailableSlot {
+	slots := []AvailableSlot{}
+	for i, first := range creneaux {
+		if first.Start.Before(from) || !first.Start.Before(to) {
+			continue
+		}
+		if _, ok := bookingSpan(creneaux[i:], duration); ok {
+			start := first.Start.In(from.Location())
+			slots = append(slots, AvailableSlot{Start: start, End: start.Add(duration), ID_creneau: first.ID_creneau})
+		}
+	}
+	return slots
+}
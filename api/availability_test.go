@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Every slot offered must be bookable, and booking one must withdraw the
+// slots it overlaps.
+func TestAvailabilityMatchesBooking(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(60)
+		start := testStart()
+		var creneaux []Creneau
+		for _, offset := range []time.Duration{0, 30, 60, 120} {
+			creneaux = append(creneaux, api.creneau(b.coiffeur.ID_coiffeur, start.Add(offset*time.Minute), 30))
+		}
+		client := api.account(RoleClient, 0, 0)
+		url := pathf("/api/availability?id_salon=%s&id_service=%s", b.salon.ID_salon, b.service.ID_service)
+
+		slots := func() []int {
+			var availability []CoiffeurAvailability
+			api.do("GET", url, client.token, nil).expect(t, http.StatusOK).decode(t, &availability)
+			if len(availability) != 1 {
+				t.Fatalf("availability %+v, want one coiffeur", availability)
+			}
+			var ids []int
+			for _, slot := range availability[0].Slots {
+				ids = append(ids, slot.ID_creneau)
+			}
+			return ids
+		}
+
+		offered := slots()
+		if len(offered) != 2 || offered[0] != creneaux[0].ID_creneau || offered[1] != creneaux[1].ID_creneau {
+			t.Fatalf("slots on creneaux %v, want %d and %d", offered, creneaux[0].ID_creneau, creneaux[1].ID_creneau)
+		}
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, offered[1])).
+			expect(t, http.StatusCreated)
+		if offered := slots(); len(offered) != 0 {
+			t.Errorf("slots on creneaux %v after booking, want none", offered)
+		}
+	})
+}
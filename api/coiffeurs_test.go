@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCoiffeurServicesUpdate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		cut := api.service(salon.ID_salon, 30)
+		color := api.service(salon.ID_salon, 90)
+		coiffeur := api.coiffeur(salon.ID_salon, cut.ID_service)
+		url := pathf("/api/v2/coiffeurs/%s", coiffeur.ID_coiffeur)
+
+		tests := []struct {
+			name   string
+			method string
+			body   string
+			want   []int
+		}{
+			{"patch without services", "PATCH", `{"firstname":"Alex"}`, []int{cut.ID_service}},
+			{"put without services", "PUT", fmt.Sprintf(`{"id_salon":%d,"firstname":"Alex","lastname":"Martin"}`, salon.ID_salon), []int{cut.ID_service}},
+			{"patch with duplicates", "PATCH", fmt.Sprintf(`{"services":[%d,%d,%d]}`, color.ID_service, cut.ID_service, color.ID_service), []int{cut.ID_service, color.ID_service}},
+			{"patch with null services", "PATCH", `{"services":null}`, nil},
+		}
+		for _, test := range tests {
+			var updated, stored Coiffeur
+			api.do(test.method, url, api.admin, test.body, "If-Match", "*").expect(t, http.StatusOK).decode(t, &updated)
+			r := api.do("GET", url, api.admin, nil).expect(t, http.StatusOK)
+			r.decode(t, &stored)
+
+			slices.Sort(stored.Services)
+			if !slices.Equal(stored.Services, test.want) {
+				t.Errorf("%s: services %v, want %v", test.name, stored.Services, test.want)
+			}
+			slices.Sort(updated.Services)
+			if !slices.Equal(updated.Services, stored.Services) || updated.Version != stored.Version {
+				t.Errorf("%s: response %+v, stored %+v", test.name, updated, stored)
+			}
+		}
+	})
+}
+
+func TestListCoiffeursServices(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		cut := api.service(salon.ID_salon, 30)
+		color := api.service(salon.ID_salon, 90)
+		want := map[int][]int{}
+		for _, services := range [][]int{{cut.ID_service}, {cut.ID_service, color.ID_service}, nil} {
+			coiffeur := api.coiffeur(salon.ID_salon, services...)
+			want[coiffeur.ID_coiffeur] = services
+		}
+
+		var page []Coiffeur
+		api.do("GET", pathf("/api/v2/coiffeurs?id_salon=%s&sort=id&limit=2", salon.ID_salon), api.admin, nil).
+			expect(t, http.StatusOK).decode(t, &page)
+		if len(page) != 2 {
+			t.Fatalf("%d coiffeurs listed, want 2", len(page))
+		}
+		for _, coiffeur := range page {
+			slices.Sort(coiffeur.Services)
+			if !slices.Equal(coiffeur.Services, want[coiffeur.ID_coiffeur]) {
+				t.Errorf("coiffeur %d services %v, want %v", coiffeur.ID_coiffeur, coiffeur.Services, want[coiffeur.ID_coiffeur])
+			}
+		}
+	})
+}
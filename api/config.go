@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective configuration of the API. It is built from, in
+// increasing order of precedence:
+//
+//  1. the defaults below,
+//  2. the YAML file named by -config or CONFIG_FILE, if any,
+//  3. environment variables,
+//  4. command-line flags.
+//
+// config.example.yaml lists every key with its variable and flag.
+type Config struct {
+	Listen         string          `yaml:"listen"`
+	Database       DatabaseConfig  `yaml:"database"`
+	Timeouts       TimeoutsConfig  `yaml:"timeouts"`
+	MaxHeaderBytes int             `yaml:"max_header_bytes"`
+	MaxBodyBytes   int64           `yaml:"max_body_bytes"`
+	LogLevel       string          `yaml:"log_level"`
+	Tracing        TracingConfig   `yaml:"tracing"`
+	CORSOrigins    []string        `yaml:"cors_origins"`
+	Features       map[string]bool `yaml:"features"`
+}
+
+// An empty DSN selects the driver's default. ConnectTimeout bounds how long
+// startup keeps retrying a database that is not reachable yet.
+type DatabaseConfig struct {
+	Driver          string        `yaml:"driver"`
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	// LegacyTimezone is the zone the text dates of creneaux created before
+	// timestamps were written in. Migration 2 needs it to convert them.
+	LegacyTimezone string `yaml:"legacy_timezone"`
+}
+
+// Drain is how long /readyz reports the server as shutting down, so that load
+// balancers stop sending it traffic, before it stops accepting connections.
+// Shutdown is how long in-flight requests then get to finish.
+type TimeoutsConfig struct {
+	Read     time.Duration `yaml:"read"`
+	Write    time.Duration `yaml:"write"`
+	Idle     time.Duration `yaml:"idle"`
+	Drain    time.Duration `yaml:"drain"`
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// Exporter is none, otlp or stdout. Endpoint is the OTLP/HTTP collector URL,
+// e.g. http://localhost:4318; when empty the exporter falls back to
+// OTEL_EXPORTER_OTLP_ENDPOINT and then to localhost.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Feature toggles and their defaults. Unknown names are rejected so that a
+// typo does not silently keep the default.
+var features = map[string]bool{
+	// signup lets anyone create a client account; when off, only admins can.
+	"signup": true,
+}
+
+func defaultConfig() Config {
+	cfg := Config{
+		Listen: ":8080",
+		Database: DatabaseConfig{
+			Driver:          "mysql",
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnectTimeout:  time.Minute,
+		},
+		Timeouts: TimeoutsConfig{
+			Read:     15 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     120 * time.Second,
+			Drain:    5 * time.Second,
+			Shutdown: 20 * time.Second,
+		},
+		MaxHeaderBytes: 1 << 20,
+		MaxBodyBytes:   1 << 20,
+		LogLevel:       "info",
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Features: map[string]bool{},
+	}
+	for name, enabled := range features {
+		cfg.Features[name] = enabled
+	}
+	return cfg
+}
+
+// A setting is one configuration value that can be overridden from the
+// environment and the command line.
+type setting struct {
+	flag, env, usage string
+	set              func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"listen", "LISTEN_ADDR", "address to listen on, host:port", func(cfg *Config, v string) error {
+		cfg.Listen = v
+		return nil
+	}},
+	{"db-driver", "DB_DRIVER", "database driver: mysql, postgres or sqlite", func(cfg *Config, v string) error {
+		cfg.Database.Driver = v
+		return nil
+	}},
+	{"db-dsn", "DB_DSN", "database connection string", func(cfg *Config, v string) error {
+		cfg.Database.DSN = v
+		return nil
+	}},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum open database connections, 0 for no limit", func(cfg *Config, v string) error {
+		return setInt(&cfg.Database.MaxOpenConns, v)
+	}},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum idle database connections", func(cfg *Config, v string) error {
+		return setInt(&cfg.Database.MaxIdleConns, v)
+	}},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum age of a database connection, 0 to keep forever", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Database.ConnMaxLifetime, v)
+	}},
+	{"db-connect-timeout", "DB_CONNECT_TIMEOUT", "how long to retry the database at startup, 0 to try once", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Database.ConnectTimeout, v)
+	}},
+	{"db-legacy-timezone", "DB_LEGACY_TIMEZONE", "time zone of the legacy creneau dates, e.g. Europe/Paris", func(cfg *Config, v string) error {
+		cfg.Database.LegacyTimezone = v
+		return nil
+	}},
+	{"read-timeout", "READ_TIMEOUT", "time allowed to read a request", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Timeouts.Read, v)
+	}},
+	{"write-timeout", "WRITE_TIMEOUT", "time allowed to write a response", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Timeouts.Write, v)
+	}},
+	{"idle-timeout", "IDLE_TIMEOUT", "time an idle keep-alive connection stays open", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Timeouts.Idle, v)
+	}},
+	{"drain-delay", "DRAIN_DELAY", "time /readyz fails on SIGINT or SIGTERM before connections are refused", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Timeouts.Drain, v)
+	}},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time in-flight requests get to finish on SIGINT or SIGTERM", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Timeouts.Shutdown, v)
+	}},
+	{"max-header-bytes", "MAX_HEADER_BYTES", "maximum size of request headers", func(cfg *Config, v string) error {
+		return setInt(&cfg.MaxHeaderBytes, v)
+	}},
+	{"max-body-bytes", "MAX_BODY_BYTES", "maximum size of a request body", func(cfg *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		cfg.MaxBodyBytes = n
+		return nil
+	}},
+	{"log-level", "LOG_LEVEL", "log level: " + strings.Join(logLevels, ", "), func(cfg *Config, v string) error {
+		cfg.LogLevel = v
+		return nil
+	}},
+	{"tracing-exporter", "TRACING_EXPORTER", "where to send traces: " + strings.Join(tracingExporters, ", "), func(cfg *Config, v string) error {
+		cfg.Tracing.Exporter = v
+		return nil
+	}},
+	{"otlp-endpoint", "OTLP_ENDPOINT", "OTLP/HTTP collector URL, e.g. http://localhost:4318", func(cfg *Config, v string) error {
+		cfg.Tracing.Endpoint = v
+		return nil
+	}},
+	{"trace-sample-ratio", "TRACE_SAMPLE_RATIO", "share of new traces to record, from 0 to 1", func(cfg *Config, v string) error {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		cfg.Tracing.SampleRatio = ratio
+		return nil
+	}},
+	{"cors-origins", "CORS_ORIGINS", "comma-separated origins allowed by CORS, * for any", func(cfg *Config, v string) error {
+		cfg.CORSOrigins = splitList(v)
+		return nil
+	}},
+	{"features", "FEATURES", "comma-separated feature toggles, e.g. signup=false", func(cfg *Config, v string) error {
+		for _, item := range splitList(v) {
+			name, value, found := strings.Cut(item, "=")
+			enabled := true
+			if found {
+				var err error
+				enabled, err = strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("feature %s: %w", name, err)
+				}
+			}
+			cfg.Features[name] = enabled
+		}
+		return nil
+	}},
+}
+
+func setInt(dst *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setDuration(dst *time.Duration, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfig builds the configuration from the file, the environment and the
+// flags in args, validates it and returns the arguments left after the flags.
+func loadConfig(args []string) (Config, []string, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("main", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+	values := map[string]*string{}
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+			return cfg, nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, value); err != nil {
+				return cfg, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.set(&cfg, *values[s.flag]); setErr != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	return cfg, fs.Args(), cfg.validate()
+}
+
+// validate reports every invalid value at once.
+func (cfg Config) validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("listen: %q is not host:port", cfg.Listen))
+	}
+	if _, ok := dialects[cfg.Database.Driver]; !ok {
+		problems = append(problems, fmt.Sprintf("database.driver: unknown driver %q", cfg.Database.Driver))
+	}
+	if tz := cfg.Database.LegacyTimezone; tz != "" && !validTimezone(tz) {
+		problems = append(problems, fmt.Sprintf("database.legacy_timezone: %q is not an IANA time zone", tz))
+	}
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database: connection counts cannot be negative")
+	}
+	if cfg.Database.ConnMaxLifetime < 0 || cfg.Database.ConnectTimeout < 0 || cfg.Timeouts.Read < 0 || cfg.Timeouts.Write < 0 || cfg.Timeouts.Idle < 0 || cfg.Timeouts.Drain < 0 || cfg.Timeouts.Shutdown < 0 {
+		problems = append(problems, "durations cannot be negative")
+	}
+	if cfg.MaxHeaderBytes <= 0 || cfg.MaxBodyBytes <= 0 {
+		problems = append(problems, "max_header_bytes and max_body_bytes must be positive")
+	}
+	if !contains(logLevels, cfg.LogLevel) {
+		problems = append(problems, fmt.Sprintf("log_level: %q is not one of %s", cfg.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	if !contains(tracingExporters, cfg.Tracing.Exporter) {
+		problems = append(problems, fmt.Sprintf("tracing.exporter: %q is not one of %s", cfg.Tracing.Exporter, strings.Join(tracingExporters, ", ")))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		problems = append(problems, "tracing.sample_ratio must be between 0 and 1")
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			problems = append(problems, fmt.Sprintf("cors_origins: %q is not an origin such as https://example.com", origin))
+		}
+	}
+	for name := range cfg.Features {
+		if _, ok := features[name]; !ok {
+			problems = append(problems, fmt.Sprintf("features: unknown feature %q", name))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+var dsnPassword = regexp.MustCompile(`(?i)(password=)[^ &]*`)
+
+// maskDSN hides the password of a connection string in any of the forms the
+// drivers accept: user:password@tcp(host)/db, URLs and key=value lists.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return strings.Replace(u.String(), "xxxxx", "****", 1)
+		}
+	}
+	if at := strings.LastIndex(dsn, "@"); at >= 0 {
+		if colon := strings.Index(dsn[:at], ":"); colon >= 0 && !strings.Contains(dsn[:at], "://") {
+			return dsn[:colon+1] + "****" + dsn[at:]
+		}
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}****")
+}
+
+// runConfigCommand implements
+//
+//	main config print    show the effective configuration, secrets masked
+func runConfigCommand(cfg Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+
+	if cfg.Database.DSN == "" {
+		cfg.Database.DSN = dialects[cfg.Database.Driver].defaultDSN
+	}
+	cfg.Database.DSN = maskDSN(cfg.Database.DSN)
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(out))
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+listen: ":7000"
+log_level: debug
+database:
+  driver: postgres
+  max_open_conns: 7
+timeouts:
+  read: 3s
+`
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("LISTEN_ADDR", ":7100")
+
+	cfg, args, err := loadConfig([]string{"-listen", ":7200", "config", "print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"flag over environment", cfg.Listen, ":7200"},
+		{"environment over file", cfg.LogLevel, "warn"},
+		{"environment over file", cfg.Database.Driver, "sqlite"},
+		{"file over default", cfg.Database.MaxOpenConns, 7},
+		{"file over default", cfg.Timeouts.Read, 3 * time.Second},
+		{"default", cfg.Timeouts.Write, defaultConfig().Timeouts.Write},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+	if strings.Join(args, " ") != "config print" {
+		t.Errorf("args %q, want the command after the flags", args)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	t.Setenv("DB_DRIVER", "oracle")
+	t.Setenv("LOG_LEVEL", "loud")
+
+	_, _, err := loadConfig(nil)
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, key := range []string{"database.driver", "log_level"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+
+	if _, _, err := loadConfig([]string{"-db-max-open-conns", "many"}); err == nil {
+		t.Error("non-numeric flag accepted")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+// cors lets the browsers of the configured origins call the API and answers
+// their preflight requests. Without configured origins it adds nothing.
+func (s *server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(s.config.CORSOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if contains(s.config.CORSOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if contains(s.config.CORSOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Link, X-Next-Cursor, X-Request-ID, X-Total-Count")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CORSOrigins = []string{"https://app.example.com"}
+	server := httptest.NewServer(newServer(newMemoryStore(), cfg).routes())
+	defer server.Close()
+
+	request := func(method, origin string, header ...string) http.Header {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+"/api/v2/salons", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header
+	}
+	has := func(list, name string) bool {
+		for _, item := range strings.Split(list, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	preflight := request("OPTIONS", "https://app.example.com", "Access-Control-Request-Method", "PUT")
+	if got := preflight.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin %q", got)
+	}
+	for _, name := range []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Request-ID"} {
+		if allowed := preflight.Get("Access-Control-Allow-Headers"); !has(allowed, name) {
+			t.Errorf("Access-Control-Allow-Headers %q lacks %s", allowed, name)
+		}
+	}
+
+	header := request("GET", "https://app.example.com")
+	for _, name := range []string{"ETag", "Link", "X-Next-Cursor", "X-Request-ID", "X-Total-Count"} {
+		if exposed := header.Get("Access-Control-Expose-Headers"); !has(exposed, name) {
+			t.Errorf("Access-Control-Expose-Headers %q lacks %s", exposed, name)
+		}
+	}
+
+	if got := request("GET", "https://evil.example.com").Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("unknown origin allowed: %q", got)
+	}
+}
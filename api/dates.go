@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultTimezone = "UTC"
+
+// Layouts accepted for the free-form date_creneau values written before
+// creneaux had real timestamps. They are read in legacyTimezone.
+var legacyDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+}
+
+// legacyTimezone is the zone date_creneau values were written in, from the
+// configuration. Salons had no zone then, so theirs cannot tell.
+var legacyTimezone string
+
+var locations sync.Map
+
+// salonLocation returns the time zone named by a salon, falling back to UTC
+// for empty or unknown names.
+func salonLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// defaultEnd makes a creneau without an end last one slot.
+func (c *Creneau) defaultEnd() {
+	if c.End.IsZero() && !c.Start.IsZero() {
+		c.End = c.Start.Add(defaultSlotMinutes * time.Minute)
+	}
+}
+
+// validTimezone accepts IANA zone names. "Local" is refused: it would mean
+// whatever zone the server happens to run in.
+func validTimezone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return name != "" && name != "Local" && err == nil
+}
+
+// coiffeurLocation returns the time zone of the salon a coiffeur works in,
+// or UTC when the coiffeur or salon does not exist.
+func (s *server) coiffeurLocation(ctx context.Context, idCoiffeur int) (*time.Location, error) {
+	coiffeur, err := s.coiffeurs.GetCoiffeur(ctx, idCoiffeur)
+	if err == errNotFound {
+		return time.UTC, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	salon, err := s.salons.GetSalon(ctx, coiffeur.ID_salon)
+	if err == errNotFound {
+		return time.UTC, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return salonLocation(salon.Timezone), nil
+}
+
+func parseLegacyDate(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range legacyDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// convertCreneauDates fills start_at and end_at for creneaux that only have a
+// date_creneau string, reading it in legacyTimezone and storing UTC. It
+// refuses to guess the zone when there are rows to convert and none is
+// configured. Rows that cannot be parsed are left untouched and listed in the
+// log so they can be fixed by hand through /api/creneaux/update; until then
+// they do not appear in listings or availability.
+func convertCreneauDates(c migrationConn) error {
+	rows, err := c.Query(`
+		SELECT id_creneau, date_creneau, duration_minutes
+		FROM creneaux
+		WHERE start_at IS NULL AND date_creneau IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+
+	type legacyCreneau struct {
+		id       int
+		date     string
+		duration int
+	}
+	var legacy []legacyCreneau
+	for rows.Next() {
+		var creneau legacyCreneau
+		if err := rows.Scan(&creneau.id, &creneau.date, &creneau.duration); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, creneau)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+	if legacyTimezone == "" {
+		return fmt.Errorf("%d creneaux have a text date: set database.legacy_timezone (DB_LEGACY_TIMEZONE) to the zone they were written in", len(legacy))
+	}
+	loc, err := time.LoadLocation(legacyTimezone)
+	if err != nil {
+		return err
+	}
+
+	converted, failed := 0, 0
+	for _, creneau := range legacy {
+		start, ok := parseLegacyDate(creneau.date, loc)
+		if !ok {
+			slog.Warn("cannot parse date_creneau", "id_creneau", creneau.id, "date_creneau", creneau.date)
+			failed++
+			continue
+		}
+
+		end := start.Add(time.Duration(creneau.duration) * time.Minute)
+		_, err := c.Exec("UPDATE creneaux SET start_at=?, end_at=? WHERE id_creneau=?", start.UTC(), end.UTC(), creneau.id)
+		if err != nil {
+			return err
+		}
+		converted++
+	}
+
+	slog.Info("creneau dates converted", "converted", converted, "failed", failed, "timezone", legacyTimezone)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidTimezone(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Europe/Paris": true,
+		"UTC":          true,
+		"":             false,
+		"Local":        false,
+		"Mars/Olympus": false,
+	} {
+		if got := validTimezone(name); got != want {
+			t.Errorf("validTimezone(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+// The conversion only runs on MySQL; a legacy creneaux table on SQLite is
+// enough to exercise it.
+func TestConvertCreneauDates(t *testing.T) {
+	cfg := defaultConfig().Database
+	cfg.Driver = "sqlite"
+	cfg.DSN = "file:" + filepath.Join(t.TempDir(), "legacy.db")
+	conn, d, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, dbDialect = conn, d
+	t.Cleanup(func() {
+		conn.Close()
+		db, dbDialect, legacyTimezone = nil, nil, ""
+	})
+	err = execAll(
+		"CREATE TABLE creneaux (id_creneau INTEGER PRIMARY KEY, date_creneau VARCHAR(150), duration_minutes INT NOT NULL DEFAULT 30, start_at DATETIME, end_at DATETIME)",
+		"INSERT INTO creneaux (id_creneau, date_creneau) VALUES (1, '2024-07-01 10:00'), (2, 'someday')",
+	)(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyTimezone = ""
+	if err := convertCreneauDates(db); err == nil || !strings.Contains(err.Error(), "legacy_timezone") {
+		t.Fatalf("converted without a legacy time zone: %v", err)
+	}
+
+	legacyTimezone = "Europe/Paris"
+	if err := convertCreneauDates(db); err != nil {
+		t.Fatal(err)
+	}
+	var start, end time.Time
+	if err := db.QueryRow("SELECT start_at, end_at FROM creneaux WHERE id_creneau=1").Scan(&start, &end); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	if !start.Equal(want) || !end.Equal(want.Add(30*time.Minute)) {
+		t.Errorf("converted to %s - %s, want %s - %s", start, end, want, want.Add(30*time.Minute))
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMySQLDSNParsesTime(t *testing.T) {
+	for _, dsn := range []string{
+		"goteam:root@tcp(mysql:3306)/golang",
+		"goteam:root@tcp(mysql:3306)/golang?parseTime=false&charset=utf8mb4",
+	} {
+		got, err := mysqlDSN(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, "parseTime=true") {
+			t.Errorf("mysqlDSN(%q) = %q, want parseTime=true", dsn, got)
+		}
+	}
+	if _, err := mysqlDSN("not a dsn"); err == nil {
+		t.Error("invalid DSN accepted")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	got := dialects["postgres"].rebind("SELECT a FROM t WHERE b=? AND c=?")
+	if want := "SELECT a FROM t WHERE b=$1 AND c=$2"; got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a resource at a version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch reads the version a write expects from If-Match, 0 when the header
+// is absent or *. Tags that cannot be ours, such as weak ones, never match
+// and are answered with 412 right away. It answers r itself and returns false
+// when the write must not go ahead.
+func ifMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if strings.Contains(header, ",") {
+		badRequest(w, r, "invalid_precondition", "If-Match must hold a single entity tag or *")
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		preconditionFailed(w, r)
+		return 0, false
+	}
+	return version, true
+}
+
+// notModified sets the ETag of a resource at version and answers 304 when
+// If-None-Match already holds it. Expanded representations also depend on
+// the related objects, so they are not tagged.
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch refuses writes that do not say which version of the resource
+// they were based on, so that clients cannot overwrite changes they have not
+// seen.
+func requireIfMatch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") == "" {
+			writeProblem(w, r, http.StatusPreconditionRequired, "precondition_required", "send the ETag of the resource in If-Match")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func preconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusPreconditionFailed, "precondition_failed", "the resource has changed since the version given in If-Match")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPreconditions(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		url := pathf("/api/v2/salons/%s", salon.ID_salon)
+
+		r := api.do("GET", url, api.admin, nil).expect(t, http.StatusOK)
+		if tag := r.Header.Get("ETag"); tag != etag(1) {
+			t.Fatalf("ETag %q, want %q", tag, etag(1))
+		}
+		api.do("GET", url, api.admin, nil, "If-None-Match", etag(1)).expect(t, http.StatusNotModified)
+
+		if code := api.do("PATCH", url, api.admin, `{"name":"A"}`).expect(t, http.StatusPreconditionRequired).problem(t); code != "precondition_required" {
+			t.Errorf("code %q, want precondition_required", code)
+		}
+		api.do("PATCH", url, api.admin, `{"name":"A"}`, "If-Match", etag(1)).expect(t, http.StatusOK)
+
+		// The first writer won; a second one based on the same version loses.
+		if code := api.do("PATCH", url, api.admin, `{"name":"B"}`, "If-Match", etag(1)).expect(t, http.StatusPreconditionFailed).problem(t); code != "precondition_failed" {
+			t.Errorf("code %q, want precondition_failed", code)
+		}
+		api.do("DELETE", url, api.admin, nil, "If-Match", etag(1)).expect(t, http.StatusPreconditionFailed)
+		api.do("GET", url, api.admin, nil, "If-None-Match", etag(1)).expect(t, http.StatusOK)
+
+		// v1 writes require If-Match too.
+		salon.Name = "C"
+		api.do("PUT", "/api/salons/update", api.admin, salon).expect(t, http.StatusPreconditionRequired)
+		api.do("PUT", "/api/salons/update", api.admin, salon, "If-Match", etag(1)).expect(t, http.StatusPreconditionFailed)
+		api.do("PUT", "/api/salons/update", api.admin, salon, "If-Match", etag(2)).expect(t, http.StatusOK)
+	})
+}
+
+func TestServiceAndHorairePreconditions(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		salon := api.salon()
+		service := api.service(salon.ID_salon, 30)
+		coiffeur := api.coiffeur(salon.ID_salon)
+		var horaire Horaire
+		api.create("/api/v2/horaires", Horaire{ID_coiffeur: coiffeur.ID_coiffeur, Weekday: 1, Start: "09:00", End: "12:00"}, &horaire)
+
+		for _, tc := range []struct {
+			url  string
+			body any
+		}{
+			{pathf("/api/v2/services/%s", service.ID_service), service},
+			{pathf("/api/v2/horaires/%s", horaire.ID_horaire), horaire},
+		} {
+			r := api.do("GET", tc.url, api.admin, nil).expect(t, http.StatusOK)
+			if tag := r.Header.Get("ETag"); tag != etag(1) {
+				t.Fatalf("%s: ETag %q, want %q", tc.url, tag, etag(1))
+			}
+			api.do("GET", tc.url, api.admin, nil, "If-None-Match", etag(1)).expect(t, http.StatusNotModified)
+
+			api.do("PUT", tc.url, api.admin, tc.body).expect(t, http.StatusPreconditionRequired)
+			r = api.do("PUT", tc.url, api.admin, tc.body, "If-Match", etag(1)).expect(t, http.StatusOK)
+			if tag := r.Header.Get("ETag"); tag != etag(2) {
+				t.Errorf("%s: ETag %q after update, want %q", tc.url, tag, etag(2))
+			}
+			api.do("PUT", tc.url, api.admin, tc.body, "If-Match", etag(1)).expect(t, http.StatusPreconditionFailed)
+
+			api.do("DELETE", tc.url, api.admin, nil).expect(t, http.StatusPreconditionRequired)
+			api.do("DELETE", tc.url, api.admin, nil, "If-Match", etag(1)).expect(t, http.StatusPreconditionFailed)
+			api.do("DELETE", tc.url, api.admin, nil, "If-Match", etag(2)).expect(t, http.StatusOK)
+		}
+
+		// v1 writes require If-Match too.
+		service = api.service(salon.ID_salon, 30)
+		api.do("PUT", "/api/services/update", api.admin, service).expect(t, http.StatusPreconditionRequired)
+		api.do("PUT", "/api/services/update", api.admin, service, "If-Match", etag(1)).expect(t, http.StatusOK)
+		api.do("DELETE", pathf("/api/services/delete?id_service=%s", service.ID_service), api.admin, nil, "If-Match", etag(1)).
+			expect(t, http.StatusPreconditionFailed)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// parseExpand reads the related objects a single-resource GET should inline,
+// given as expand=a,b or repeated expand parameters. It answers r itself and
+// returns false when one of them is not in allowed.
+func parseExpand(w http.ResponseWriter, r *http.Request, allowed ...string) (map[string]bool, bool) {
+	expand := map[string]bool{}
+	for _, value := range r.URL.Query()["expand"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !contains(allowed, name) {
+				writeProblem(w, r, http.StatusBadRequest, "invalid_parameter", "expand must be a list of "+strings.Join(allowed, ", "),
+					FieldError{Field: "expand", Code: "invalid", Message: "cannot expand " + name})
+				return nil, false
+			}
+			expand[name] = true
+		}
+	}
+	return expand, true
+}
+
+// An expansion is a related object inlined under name in a resource.
+type expansion struct {
+	name  string
+	value any
+}
+
+// writeExpanded answers with v, which must encode as a JSON object, its
+// expansions added as extra members. An expansion cannot replace a field.
+func writeExpanded(w http.ResponseWriter, r *http.Request, v any, expansions []expansion) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	for _, e := range expansions {
+		if _, ok := object[e.name]; ok {
+			internalError(w, r, fmt.Errorf("expansion %q shadows a field", e.name))
+			return
+		}
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		object[e.name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(object)
+}
+
+// related turns a lookup of an expanded object into its value, null when it
+// no longer exists.
+func related[T any](v T, err error) (any, error) {
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+
+		get := func(url string, v any) {
+			t.Helper()
+			r := api.do("GET", url, api.admin, nil).expect(t, http.StatusOK)
+			if tag := r.Header.Get("ETag"); tag != "" {
+				t.Errorf("%s: expanded representation tagged %s", url, tag)
+			}
+			r.decode(t, v)
+		}
+
+		var salon struct {
+			Salon
+			Coiffeurs []Coiffeur `json:"coiffeurs"`
+			Services  []Service  `json:"services"`
+		}
+		get(pathf("/api/v2/salons/%s?expand=coiffeurs,services", b.salon.ID_salon), &salon)
+		if salon.Name != b.salon.Name || len(salon.Coiffeurs) != 1 || len(salon.Services) != 1 {
+			t.Errorf("expanded salon %+v", salon)
+		}
+
+		var coiffeur struct {
+			Coiffeur
+			Salon          *Salon    `json:"salon"`
+			ServiceDetails []Service `json:"service_details"`
+		}
+		get(pathf("/api/v2/coiffeurs/%s?expand=salon&expand=service_details", b.coiffeur.ID_coiffeur), &coiffeur)
+		if coiffeur.Salon == nil || coiffeur.Salon.ID_salon != b.salon.ID_salon ||
+			len(coiffeur.ServiceDetails) != 1 || coiffeur.ServiceDetails[0].ID_service != b.service.ID_service ||
+			len(coiffeur.Services) != 1 {
+			t.Errorf("expanded coiffeur %+v", coiffeur)
+		}
+
+		var members map[string]json.RawMessage
+		get(pathf("/api/v2/reservations/%s?expand=client,salon,coiffeur,creneau,service", reservation.ID_reservation), &members)
+		for _, name := range []string{"id_reservation", "client", "salon", "coiffeur", "creneau", "service"} {
+			if string(members[name]) == "" || string(members[name]) == "null" {
+				t.Errorf("expanded reservation lacks %s: %v", name, members)
+			}
+		}
+		if _, ok := members["password"]; ok {
+			t.Error("expanded client leaks its password")
+		}
+
+		api.do("GET", pathf("/api/v2/salons/%s?expand=owner", b.salon.ID_salon), api.admin, nil).expect(t, http.StatusBadRequest)
+	})
+}
+
+func TestWriteExpanded(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+
+	w := httptest.NewRecorder()
+	writeExpanded(w, r, struct{}{}, []expansion{{"salon", nil}, {"services", []Service{}}})
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &members); err != nil {
+		t.Fatalf("%s: %v", w.Body, err)
+	}
+	if string(members["salon"]) != "null" || string(members["services"]) != "[]" {
+		t.Errorf("expanded empty object %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	writeExpanded(w, r, Coiffeur{}, []expansion{{"services", []Service{}}})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expansion shadowing a field: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	readyTimeout      = 2 * time.Second
+	connectRetryFirst = 500 * time.Millisecond
+	connectRetryMax   = 10 * time.Second
+)
+
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// HEALTH
+// healthzHandler tells that the process is alive and serving; it checks no
+// dependency, so a database outage does not get the container restarted.
+func (s *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthStatus{Status: "ok"})
+}
+
+// readyzHandler tells whether the server should receive traffic: the
+// database answers, its schema is up to date and shutdown has not started.
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{Status: "ready"}
+	code := http.StatusOK
+	if s.draining.Load() {
+		status.Status = "shutting down"
+		code = http.StatusServiceUnavailable
+	} else if s.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := s.ready(ctx); err != nil {
+			slog.WarnContext(ctx, "not ready", "err", err)
+			status.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// dbReady checks that the database answers and has every migration applied.
+func dbReady(ctx context.Context) error {
+	err := db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	var version sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if int(version.Int64) != latestVersion() {
+		return fmt.Errorf("schema at version %d, want %d", version.Int64, latestVersion())
+	}
+	return nil
+}
+
+// waitForDB pings the database until it answers, waiting twice as long after
+// each failure, and gives up after timeout or when ctx is cancelled. It lets
+// the API start alongside a database that is still booting.
+func waitForDB(ctx context.Context, conn *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := connectRetryFirst
+	for attempt := 1; ; attempt++ {
+		err := conn.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("database unreachable after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("database unreachable", "attempt", attempt, "retry_in", delay.String(), "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > connectRetryMax {
+			delay = connectRetryMax
+		}
+	}
+}
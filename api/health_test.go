@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForDBRetries(t *testing.T) {
+	// Nothing listens on port 1, so every ping fails at once.
+	conn, err := sql.Open("mysql", "test:test@tcp(127.0.0.1:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	err = waitForDB(context.Background(), conn, connectRetryFirst+connectRetryFirst/2)
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("error %v, want a failure after 2 attempts", err)
+	}
+	if elapsed := time.Since(start); elapsed < connectRetryFirst {
+		t.Errorf("gave up after %s, before the first retry", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForDB(ctx, conn, time.Minute); err != context.Canceled {
+		t.Errorf("error %v after cancellation, want context.Canceled", err)
+	}
+
+	openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err := waitForDB(context.Background(), db, time.Second); err != nil {
+		t.Errorf("reachable database: %v", err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	s := newServer(openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")), defaultConfig())
+	s.ready = dbReady
+	server := httptest.NewServer(s.routes())
+	defer server.Close()
+
+	probe := func(path string, code int, status string) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got HealthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != code || got.Status != status {
+			t.Errorf("%s: %d %q, want %d %q", path, resp.StatusCode, got.Status, code, status)
+		}
+	}
+
+	probe("/readyz", http.StatusOK, "ready")
+
+	if err := migrateTo(latestVersion() - 1); err != nil {
+		t.Fatal(err)
+	}
+	probe("/readyz", http.StatusServiceUnavailable, "unavailable")
+	if err := migrateTo(latestVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.draining.Store(true)
+	probe("/readyz", http.StatusServiceUnavailable, "shutting down")
+	probe("/healthz", http.StatusOK, "ok")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	timeOfDayLayout     = "15:04"
+	defaultSlotMinutes  = 30
+	defaultHorizonWeeks = 4
+	maxHorizonWeeks     = 52
+)
+
+// Horaire is one working period of a coiffeur's weekly template. A day with a
+// lunch break is described by two horaires, e.g. 09:00-12:00 and 13:00-18:00.
+// Weekday follows time.Weekday: 0 is Sunday.
+type Horaire struct {
+	ID_horaire  int    `json:"id_horaire"`
+	ID_coiffeur int    `json:"id_coiffeur" validate:"required,ref=coiffeur"`
+	Weekday     int    `json:"weekday" validate:"min=0,max=6"`
+	Start       string `json:"start_time" validate:"required,time_of_day"`
+	End         string `json:"end_time" validate:"required,time_of_day,after=Start"`
+	Version     int    `json:"version"`
+}
+
+type GenerateRequest struct {
+	ID_coiffeur int `json:"id_coiffeur"`
+	Weeks       int `json:"weeks"`
+	SlotMinutes int `json:"slot_minutes"`
+}
+
+type GenerateResult struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"`
+}
+
+// HORAIRES
+func (s *server) addHoraireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var newHoraire Horaire
+	err := json.NewDecoder(r.Body).Decode(&newHoraire)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	if !s.valid(w, r, newHoraire, onCreate) {
+		return
+	}
+
+	err = s.horaires.AddHoraire(r.Context(), &newHoraire)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newHoraire)
+}
+
+// getHorairesHandler lists working hours, optionally for one coiffeur with
+// ?id_coiffeur=.
+func (s *server) getHorairesHandler(w http.ResponseWriter, r *http.Request) {
+	var idCoiffeur int
+	if idParam := r.URL.Query().Get("id_coiffeur"); idParam != "" {
+		var err error
+		idCoiffeur, err = strconv.Atoi(idParam)
+		if err != nil {
+			invalidParam(w, r, "id_coiffeur")
+			return
+		}
+	}
+
+	horaireList, err := s.horaires.ListHoraires(r.Context(), idCoiffeur)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if horaireList == nil {
+		horaireList = []Horaire{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(horaireList)
+}
+
+func (s *server) getHoraireHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_horaire"))
+	if err != nil {
+		invalidParam(w, r, "id_horaire")
+		return
+	}
+
+	horaire, err := s.horaires.GetHoraire(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if notModified(w, r, horaire.Version) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(horaire)
+}
+
+func (s *server) updateHoraireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updatedHoraire Horaire
+	err := json.NewDecoder(r.Body).Decode(&updatedHoraire)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	updatedHoraire.Version = version
+	if !s.valid(w, r, updatedHoraire, onUpdate) {
+		return
+	}
+
+	err = s.horaires.UpdateHoraire(r.Context(), &updatedHoraire)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updatedHoraire.Version))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updatedHoraire)
+}
+
+func (s *server) deleteHoraireHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	idParam := r.URL.Query().Get("id_horaire")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		invalidParam(w, r, "id_horaire")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err = s.horaires.DeleteHoraire(r.Context(), id, version)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GENERATION
+// generateCreneauxHandler materializes creneaux from the working hours of a
+// coiffeur (or of every coiffeur when id_coiffeur is 0) for the coming weeks.
+// It can be called repeatedly, e.g. nightly, to keep a rolling horizon open.
+func (s *server) generateCreneauxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var request GenerateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	var fieldErrors []FieldError
+	if request.Weeks < 0 || request.Weeks > maxHorizonWeeks {
+		fieldErrors = append(fieldErrors, FieldError{Field: "weeks", Code: "out_of_range", Message: fmt.Sprintf("must be between 0 and %d", maxHorizonWeeks)})
+	}
+	if request.SlotMinutes < 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "slot_minutes", Code: "out_of_range", Message: "cannot be negative"})
+	}
+	if len(fieldErrors) > 0 {
+		invalidFields(w, r, fieldErrors...)
+		return
+	}
+	if request.Weeks == 0 {
+		request.Weeks = defaultHorizonWeeks
+	}
+	if request.SlotMinutes == 0 {
+		request.SlotMinutes = defaultSlotMinutes
+	}
+
+	s.generateMu.Lock()
+	defer s.generateMu.Unlock()
+	result, err := s.generateCreneaux(r.Context(), request, time.Now())
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// generateCreneaux inserts one available creneau per slot of every horaire
+// between now and the horizon. Slots already in the past, and slots that
+// overlap a creneau of the coiffeur (free or booked), are skipped, so running
+// it twice creates nothing new.
+func (s *server) generateCreneaux(ctx context.Context, request GenerateRequest, now time.Time) (GenerateResult, error) {
+	var result GenerateResult
+
+	horaireList, err := s.horaires.ListHoraires(ctx, request.ID_coiffeur)
+	if err != nil {
+		return result, err
+	}
+
+	existing := map[int]*busyTimes{}
+	slot := time.Duration(request.SlotMinutes) * time.Minute
+	horizon := now.AddDate(0, 0, request.Weeks*7+1)
+
+	for _, horaire := range horaireList {
+		busy, ok := existing[horaire.ID_coiffeur]
+		if !ok {
+			creneaux, err := s.creneaux.CoiffeurCreneaux(ctx, horaire.ID_coiffeur, now, horizon)
+			if err != nil {
+				return result, err
+			}
+			busy = newBusyTimes(creneaux)
+			existing[horaire.ID_coiffeur] = busy
+		}
+
+		// Working hours are wall-clock times in the salon's time zone, so
+		// slots keep the same local time across DST changes.
+		loc, err := s.coiffeurLocation(ctx, horaire.ID_coiffeur)
+		if err != nil {
+			return result, err
+		}
+		local := now.In(loc)
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+		start, _ := time.Parse(timeOfDayLayout, horaire.Start)
+		end, _ := time.Parse(timeOfDayLayout, horaire.End)
+
+		for day := 0; day < request.Weeks*7; day++ {
+			date := today.AddDate(0, 0, day)
+			if int(date.Weekday()) != horaire.Weekday {
+				continue
+			}
+
+			from := time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+			to := time.Date(date.Year(), date.Month(), date.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+			for t := from; !t.Add(slot).After(to); t = t.Add(slot) {
+				if t.Before(now) || busy.overlaps(t, t.Add(slot)) {
+					result.Skipped++
+					continue
+				}
+
+				creneau := Creneau{ID_coiffeur: horaire.ID_coiffeur, Start: t.UTC(), End: t.Add(slot).UTC(), Availability: true}
+				err := s.creneaux.AddCreneau(ctx, &creneau)
+				if err == errDuplicate {
+					// Created meanwhile through /api/creneaux/add.
+					result.Skipped++
+					continue
+				}
+				if err != nil {
+					return result, err
+				}
+				busy.add(creneau)
+				result.Created++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// busyTimes holds creneaux of one coiffeur in chronological order, to tell
+// whether a new slot would overlap one of them.
+type busyTimes struct {
+	creneaux []Creneau
+	// longest bounds how far back a creneau overlapping a slot can start.
+	longest time.Duration
+}
+
+func newBusyTimes(creneaux []Creneau) *busyTimes {
+	busy := &busyTimes{}
+	for _, creneau := range creneaux {
+		busy.add(creneau)
+	}
+	return busy
+}
+
+func (b *busyTimes) add(creneau Creneau) {
+	i := sort.Search(len(b.creneaux), func(i int) bool { return b.creneaux[i].Start.After(creneau.Start) })
+	b.creneaux = slices.Insert(b.creneaux, i, creneau)
+	b.longest = max(b.longest, creneau.End.Sub(creneau.Start))
+}
+
+// overlaps tells whether a creneau meets [start, end).
+func (b *busyTimes) overlaps(start, end time.Time) bool {
+	i := sort.Search(len(b.creneaux), func(i int) bool { return !b.creneaux[i].Start.Before(end) })
+	for i--; i >= 0 && b.creneaux[i].Start.Add(b.longest).After(start); i-- {
+		if b.creneaux[i].End.After(start) {
+			return true
+		}
+	}
+	return false
+}
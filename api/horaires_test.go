@@ -0,0 +1,89 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGenerateSkipsOverlappingCreneaux(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		paris, err := time.LoadLocation("Europe/Paris")
+		if err != nil {
+			t.Fatal(err)
+		}
+		day := time.Now().In(paris).AddDate(0, 0, 2)
+		var horaire Horaire
+		api.create("/api/v2/horaires", Horaire{ID_coiffeur: b.coiffeur.ID_coiffeur, Weekday: int(day.Weekday()), Start: "10:00", End: "12:00"}, &horaire)
+		// Off the half-hour grid: it overlaps the 10:00 and 10:30 slots.
+		api.creneau(b.coiffeur.ID_coiffeur, time.Date(day.Year(), day.Month(), day.Day(), 10, 15, 0, 0, paris), 30)
+
+		generate := func() GenerateResult {
+			var result GenerateResult
+			api.do("POST", pathf("/api/v2/coiffeurs/%s/creneaux/generate", b.coiffeur.ID_coiffeur), api.admin, GenerateRequest{Weeks: 1}).
+				expect(t, http.StatusOK).decode(t, &result)
+			return result
+		}
+		if result := generate(); result != (GenerateResult{Created: 2, Skipped: 2}) {
+			t.Errorf("first run %+v, want 2 created and 2 skipped", result)
+		}
+		if result := generate(); result != (GenerateResult{Created: 0, Skipped: 4}) {
+			t.Errorf("second run %+v, want all 4 skipped", result)
+		}
+	})
+}
+
+func TestCreneauStartsAreUnique(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		other := api.creneau(b.coiffeur.ID_coiffeur, testStart().Add(time.Hour), 30)
+
+		r := api.do("POST", "/api/v2/creneaux", api.admin, Creneau{ID_coiffeur: b.coiffeur.ID_coiffeur, Start: testStart(), End: testStart().Add(time.Hour), Availability: true}).
+			expect(t, http.StatusConflict)
+		if code := r.problem(t); code != "duplicate" {
+			t.Errorf("code %q, want duplicate", code)
+		}
+		other.Start, other.End = testStart(), testStart().Add(30*time.Minute)
+		api.do("PUT", pathf("/api/v2/creneaux/%s", other.ID_creneau), api.admin, other, "If-Match", etag(other.Version)).
+			expect(t, http.StatusConflict)
+	})
+}
+
+func TestHoraireValidation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		coiffeur := api.coiffeur(api.salon().ID_salon)
+		tests := []struct {
+			name    string
+			path    string
+			horaire Horaire
+			want    map[string]string
+		}{
+			{"every field wrong", "/api/v2/horaires", Horaire{ID_coiffeur: 999, Weekday: 7, Start: "9h", End: "25:00"},
+				map[string]string{"id_coiffeur": "not_found", "weekday": "out_of_range", "start_time": "invalid", "end_time": "invalid"}},
+			{"end before start", "/api/v2/horaires", Horaire{ID_coiffeur: coiffeur.ID_coiffeur, Weekday: 1, Start: "12:00", End: "10:00"},
+				map[string]string{"end_time": "invalid_range"}},
+			{"unknown coiffeur in the path", "/api/v2/coiffeurs/999/horaires", Horaire{Weekday: 1, Start: "09:00", End: "12:00"},
+				map[string]string{"id_coiffeur": "not_found"}},
+		}
+		for _, test := range tests {
+			var problem Problem
+			api.do("POST", test.path, api.admin, test.horaire).expect(t, http.StatusBadRequest).decode(t, &problem)
+			got := map[string]string{}
+			for _, fieldErr := range problem.Errors {
+				got[fieldErr.Field] = fieldErr.Code
+			}
+			if !maps.Equal(got, test.want) {
+				t.Errorf("%s: errors %v, want %v", test.name, got, test.want)
+			}
+		}
+
+		var horaire Horaire
+		api.create(pathf("/api/v2/coiffeurs/%s/horaires", coiffeur.ID_coiffeur), Horaire{Weekday: 0, Start: "09:00", End: "12:00"}, &horaire)
+		if horaire.ID_coiffeur != coiffeur.ID_coiffeur || horaire.Weekday != 0 {
+			t.Errorf("created %+v", horaire)
+		}
+	})
+}
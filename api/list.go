@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// ListOptions selects one page of a list: up to Limit items, 0 meaning all of
+// them, ordered by the Sort key then by id, starting after the After cursor.
+type ListOptions struct {
+	Limit int
+	Sort  string
+	Desc  bool
+	After *Cursor
+}
+
+// A Cursor is the position of the last item of a page: its sort value and id.
+type Cursor struct {
+	Value any
+	ID    int
+}
+
+// A Page is one page of a list. Total counts every item matching the filters,
+// More tells whether items follow this page.
+type Page[T any] struct {
+	Items []T
+	Total int
+	More  bool
+}
+
+// A sortKey is a field lists may be ordered by: the column holding it and how
+// to read it from an item, as an int, float64, string or time.Time.
+type sortKey[T any] struct {
+	column string
+	value  func(T) any
+}
+
+// Sort whitelists. Every entity can be sorted by id, which is also the
+// tie-breaker that keeps cursors stable.
+var (
+	clientSorts = map[string]sortKey[Client]{
+		"id":        {"id_client", func(c Client) any { return c.ID_client }},
+		"lastname":  {"lastname", func(c Client) any { return c.Lastname }},
+		"firstname": {"firstname", func(c Client) any { return c.Firstname }},
+		"email":     {"email", func(c Client) any { return c.Email }},
+	}
+	salonSorts = map[string]sortKey[Salon]{
+		"id":   {"id_salon", func(s Salon) any { return s.ID_salon }},
+		"name": {"name", func(s Salon) any { return s.Name }},
+	}
+	coiffeurSorts = map[string]sortKey[Coiffeur]{
+		"id":        {"id_coiffeur", func(c Coiffeur) any { return c.ID_coiffeur }},
+		"lastname":  {"lastname", func(c Coiffeur) any { return c.Lastname }},
+		"firstname": {"firstname", func(c Coiffeur) any { return c.Firstname }},
+	}
+	creneauSorts = map[string]sortKey[Creneau]{
+		"id":    {"id_creneau", func(c Creneau) any { return c.ID_creneau }},
+		"start": {"start_at", func(c Creneau) any { return c.Start.UTC() }},
+	}
+	reservationSorts = map[string]sortKey[Reservation]{
+		"id":    {"r.id_reservation", func(r Reservation) any { return r.ID_reservation }},
+		"price": {"COALESCE(r.price, 0)", func(r Reservation) any { return r.Price }},
+	}
+)
+
+// Filters of the list endpoints. Zero values do not filter.
+type ClientFilter struct {
+	Role  string
+	Email string
+}
+
+// Name matches salons whose name contains it, ignoring case.
+type SalonFilter struct {
+	Name string
+}
+
+type CoiffeurFilter struct {
+	ID_salon   int
+	ID_service int
+}
+
+// From and To bound the start of the creneaux, To excluded.
+type CreneauFilter struct {
+	ID_coiffeur int
+	From, To    time.Time
+	Available   *bool
+}
+
+// Status is upcoming or past, relative to Now, by the start of the creneau.
+type ReservationFilter struct {
+	ID_client   int
+	ID_salon    int
+	ID_coiffeur int
+	Status      string
+	Now         time.Time
+}
+
+const (
+	statusUpcoming = "upcoming"
+	statusPast     = "past"
+)
+
+// compareValues orders two sort values of the same type.
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return compareOrdered(a, b.(int))
+	case float64:
+		return compareOrdered(a, b.(float64))
+	case string:
+		return compareOrdered(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic("compareValues: unsupported type")
+}
+
+func compareOrdered[V int | float64 | string](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// pageOf sorts items, already filtered, and cuts the page selected by o.
+func pageOf[T any](items []T, id func(T) int, sorts map[string]sortKey[T], o ListOptions) Page[T] {
+	key := sorts[o.Sort]
+	less := func(a, b T) int {
+		c := compareValues(key.value(a), key.value(b))
+		if c == 0 {
+			c = compareOrdered(id(a), id(b))
+		}
+		if o.Desc {
+			c = -c
+		}
+		return c
+	}
+	sort.SliceStable(items, func(i, j int) bool { return less(items[i], items[j]) < 0 })
+
+	page := Page[T]{Total: len(items)}
+	start := 0
+	if o.After != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			c := compareValues(key.value(items[i]), o.After.Value)
+			if c == 0 {
+				c = compareOrdered(id(items[i]), o.After.ID)
+			}
+			if o.Desc {
+				c = -c
+			}
+			return c > 0
+		})
+	}
+	items = items[start:]
+	if o.Limit > 0 && len(items) > o.Limit {
+		items = items[:o.Limit]
+		page.More = true
+	}
+	page.Items = items
+	return page
+}
+
+// Cursors are opaque to clients: base64 of the sort key, direction, value and
+// id of the last item, so a cursor cannot be replayed with another order.
+type cursorData struct {
+	Sort  string          `json:"s"`
+	Desc  bool            `json:"d,omitempty"`
+	Value json.RawMessage `json:"v"`
+	ID    int             `json:"id"`
+}
+
+func encodeCursor[T any](item T, id func(T) int, sorts map[string]sortKey[T], o ListOptions) string {
+	value, _ := json.Marshal(sorts[o.Sort].value(item))
+	data, _ := json.Marshal(cursorData{Sort: o.Sort, Desc: o.Desc, Value: value, ID: id(item)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor[T any](s string, sorts map[string]sortKey[T], o ListOptions) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var data cursorData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	if data.Sort != o.Sort || data.Desc != o.Desc {
+		return nil, errors.New("cursor belongs to another sort order")
+	}
+
+	var zero T
+	var value any
+	switch sorts[o.Sort].value(zero).(type) {
+	case int:
+		var v int
+		err = json.Unmarshal(data.Value, &v)
+		value = v
+	case float64:
+		var v float64
+		err = json.Unmarshal(data.Value, &v)
+		value = v
+	case string:
+		var v string
+		err = json.Unmarshal(data.Value, &v)
+		value = v
+	case time.Time:
+		var v time.Time
+		err = json.Unmarshal(data.Value, &v)
+		value = v.UTC()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{Value: value, ID: data.ID}, nil
+}
+
+// parseListOptions reads limit, sort (a whitelisted key, prefixed with - for
+// descending order) and cursor from the query string. It answers r itself
+// and returns false when they are invalid. v1 lists predate pagination, so
+// only v2 ones are cut at defaultPageSize when no limit is given.
+func parseListOptions[T any](w http.ResponseWriter, r *http.Request, sorts map[string]sortKey[T]) (ListOptions, bool) {
+	query := r.URL.Query()
+	o := ListOptions{Sort: "id"}
+	if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+		o.Limit = defaultPageSize
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and "+strconv.Itoa(maxPageSize),
+				FieldError{Field: "limit", Code: "out_of_range", Message: "must be between 1 and " + strconv.Itoa(maxPageSize)})
+			return o, false
+		}
+		o.Limit = limit
+	}
+
+	if value := query.Get("sort"); value != "" {
+		o.Sort, o.Desc = strings.CutPrefix(value, "-")
+		if _, ok := sorts[o.Sort]; !ok {
+			keys := make([]string, 0, len(sorts))
+			for key := range sorts {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			writeProblem(w, r, http.StatusBadRequest, "invalid_parameter", "sort must be one of "+strings.Join(keys, ", ")+", optionally prefixed with -",
+				FieldError{Field: "sort", Code: "invalid", Message: "is not a sortable field"})
+			return o, false
+		}
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value, sorts, o)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "invalid_parameter", "cursor is invalid or does not match the sort order",
+				FieldError{Field: "cursor", Code: "invalid", Message: "must be a next cursor returned with the same sort"})
+			return o, false
+		}
+		o.After = cursor
+	}
+	return o, true
+}
+
+// Optional filter parameters. Each returns the zero value when the parameter
+// is absent, and answers r itself and returns false when it is malformed.
+func queryInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		invalidParam(w, r, name)
+		return 0, false
+	}
+	return n, true
+}
+
+func queryTime(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		invalidParam(w, r, name)
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
+
+func queryBool(w http.ResponseWriter, r *http.Request, name string) (*bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, true
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		invalidParam(w, r, name)
+		return nil, false
+	}
+	return &b, true
+}
+
+// writePage answers with the items of page as a JSON array. The total count
+// and the cursor of the next page, if any, are given in the X-Total-Count and
+// X-Next-Cursor headers and as a Link header with rel="next".
+func writePage[T any](w http.ResponseWriter, r *http.Request, page Page[T], id func(T) int, sorts map[string]sortKey[T], o ListOptions) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.More {
+		cursor := encodeCursor(page.Items[len(page.Items)-1], id, sorts, o)
+		w.Header().Set("X-Next-Cursor", cursor)
+		w.Header().Set("Link", "<"+nextPageURL(r, cursor)+`>; rel="next"`)
+	}
+
+	items := page.Items
+	if items == nil {
+		items = []T{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func nextPageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return next.String()
+}
+
+// Item ids, for cursors.
+func clientID(c Client) int           { return c.ID_client }
+func salonID(s Salon) int             { return s.ID_salon }
+func coiffeurID(c Coiffeur) int       { return c.ID_coiffeur }
+func creneauID(c Creneau) int         { return c.ID_creneau }
+func reservationID(r Reservation) int { return r.ID_reservation }
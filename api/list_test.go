@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		for range defaultPageSize + 1 {
+			api.salon()
+		}
+
+		// v1 lists predate pagination and stay whole.
+		var salons []Salon
+		r := api.do("GET", "/api/salons", api.admin, nil).expect(t, http.StatusOK)
+		r.decode(t, &salons)
+		if len(salons) != defaultPageSize+1 || r.Header.Get("X-Next-Cursor") != "" {
+			t.Errorf("v1 listed %d salons, next cursor %q; want all %d", len(salons), r.Header.Get("X-Next-Cursor"), defaultPageSize+1)
+		}
+
+		r = api.do("GET", "/api/v2/salons", api.admin, nil).expect(t, http.StatusOK)
+		r.decode(t, &salons)
+		if len(salons) != defaultPageSize {
+			t.Errorf("v2 listed %d salons, want %d", len(salons), defaultPageSize)
+		}
+		if total := r.Header.Get("X-Total-Count"); total != strconv.Itoa(defaultPageSize+1) {
+			t.Errorf("X-Total-Count %q, want %d", total, defaultPageSize+1)
+		}
+		cursor := r.Header.Get("X-Next-Cursor")
+		if cursor == "" {
+			t.Fatal("no next cursor on a truncated page")
+		}
+
+		var rest []Salon
+		r = api.do("GET", "/api/v2/salons?cursor="+cursor, api.admin, nil).expect(t, http.StatusOK)
+		r.decode(t, &rest)
+		if len(rest) != 1 || rest[0].ID_salon <= salons[len(salons)-1].ID_salon || r.Header.Get("X-Next-Cursor") != "" {
+			t.Errorf("second page %+v, next cursor %q", rest, r.Header.Get("X-Next-Cursor"))
+		}
+
+		api.do("GET", "/api/salons?limit=2", api.admin, nil).expect(t, http.StatusOK).decode(t, &salons)
+		if len(salons) != 2 {
+			t.Errorf("v1 with limit=2 listed %d salons", len(salons))
+		}
+	})
+}
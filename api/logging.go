@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxRequestIDLength = 128
+
+// newLogger writes JSON lines at the given level (debug, info, warn or
+// error) and adds the request and trace IDs of the context to every record
+// logged with one, so errors deep in the stores can be traced back to their
+// request.
+func newLogger(w io.Writer, level string) *slog.Logger {
+	var l slog.Level
+	l.UnmarshalText([]byte(level))
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})})
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		record.AddAttrs(slog.String("request_id", info.id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestInfo is what is known about the request being served. It is shared
+// through the context so inner handlers can fill in the user.
+type requestInfo struct {
+	id       string
+	idClient int
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// setRequestUser records the authenticated client for the request log.
+func setRequestUser(ctx context.Context, idClient int) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.idClient = idClient
+	}
+}
+
+// logRequests gives each request an ID, taken from X-Request-ID when the
+// caller sends a sensible one, echoes it in the response and logs one line
+// per request once it is served.
+func (s *server) logRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		info := &requestInfo{id: id}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+
+		_, route := mux.Handler(r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if info.idClient != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.idClient))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(r rune) bool {
+		return r < '!' || r > '~'
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
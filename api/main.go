@@ -1,439 +1,657 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
-
-	_ "github.com/go-sql-driver/mysql"
+	"sync/atomic"
+	"time"
 )
 
 // TYPES
+// Version counts the changes of a row; it is served as the ETag of the
+// resource and checked against If-Match on writes.
 type Client struct {
-	ID_client int    `json:"id_client"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
+	ID_client   int    `json:"id_client"`
+	Firstname   string `json:"firstname" validate:"required,max=100"`
+	Lastname    string `json:"lastname" validate:"required,max=100"`
+	Email       string `json:"email" validate:"required,email,max=255"`
+	Password    string `json:"password" validate:"required_on_create,min=8,max=72"`
+	Role        string `json:"role"`
+	ID_salon    int    `json:"id_salon,omitempty"`
+	ID_coiffeur int    `json:"id_coiffeur,omitempty"`
+	Version     int    `json:"version"`
 }
 
+// Timezone is an IANA name such as Europe/Paris; creneau times are shown in
+// it.
 type Salon struct {
 	ID_salon int    `json:"id_salon"`
-	Name     string `json:"name"`
+	Name     string `json:"name" validate:"required,max=100"`
+	Timezone string `json:"timezone" validate:"timezone"`
+	Version  int    `json:"version"`
 }
 
 type Coiffeur struct {
 	ID_coiffeur int    `json:"id_coiffeur"`
-	ID_salon    int    `json:"id_salon"`
-	Firstname   string `json:"firstname"`
-	Lastname    string `json:"lastname"`
+	ID_salon    int    `json:"id_salon" validate:"required,ref=salon"`
+	Firstname   string `json:"firstname" validate:"required,max=100"`
+	Lastname    string `json:"lastname" validate:"required,max=100"`
+	Services    []int  `json:"services"`
+	Version     int    `json:"version"`
+}
+
+type Service struct {
+	ID_service int     `json:"id_service"`
+	ID_salon   int     `json:"id_salon" validate:"required,ref=salon"`
+	Name       string  `json:"name" validate:"required,max=100"`
+	Duration   int     `json:"duration_minutes" validate:"required,min=1,max=1440"`
+	Price      float64 `json:"price" validate:"min=0"`
+	Category   string  `json:"category" validate:"max=50"`
+	Version    int     `json:"version"`
 }
 
+// Start and End are stored in UTC and rendered in the salon's time zone.
 type Creneau struct {
-	ID_creneau   int    `json:"id_creneau"`
-	ID_coiffeur  int    `json:"id_coiffeur"`
-	Date         string `json:"date_creneau"`
-	Availability bool   `json:"availability"`
+	ID_creneau   int       `json:"id_creneau"`
+	ID_coiffeur  int       `json:"id_coiffeur" validate:"required,ref=coiffeur"`
+	Start        time.Time `json:"start" validate:"required"`
+	End          time.Time `json:"end" validate:"after=Start"`
+	Availability bool      `json:"availability"`
+	Version      int       `json:"version"`
 }
 
+// Duration and Price are copied from the service when booking, so later
+// catalogue changes do not alter existing reservations.
 type Reservation struct {
-	ID_reservation int `json:"id_reservation"`
-	ID_salon       int `json:"id_salon"`
-	ID_coiffeur    int `json:"id_coiffeur"`
-	ID_creneau     int `json:"id_creneau"`
+	ID_reservation int     `json:"id_reservation"`
+	ID_client      int     `json:"id_client" validate:"required,ref=client"`
+	ID_salon       int     `json:"id_salon" validate:"required,ref=salon"`
+	ID_coiffeur    int     `json:"id_coiffeur" validate:"required,ref=coiffeur"`
+	ID_creneau     int     `json:"id_creneau" validate:"required,ref=creneau"`
+	ID_service     int     `json:"id_service" validate:"required,ref=service"`
+	Duration       int     `json:"duration_minutes"`
+	Price          float64 `json:"price"`
+	Version        int     `json:"version"`
 }
 
 var (
-	db             *sql.DB
-	clientsMu      sync.RWMutex
-	salonsMu       sync.RWMutex
-	coiffeursMu    sync.RWMutex
-	creneauxMu     sync.RWMutex
-	reservationsMu sync.RWMutex
-	nextID         = 1
+	db        *sql.DB
+	dbDialect *dialect
+)
+
+var (
+	errClientNotFound     = errors.New("client not found")
+	errCreneauNotFound    = errors.New("creneau not found")
+	errCreneauUnavailable = errors.New("creneau already booked")
+	// A booking takes its creneau and those following it back to back
+	// until the service's duration is covered.
+	errCreneauTooShort      = errors.New("creneau not followed by enough free time for the service")
+	errCreneauOtherCoiffeur = errors.New("creneau belongs to another coiffeur")
+	// A reservation keeps the service, and so the duration and price, it
+	// was booked with; changing them means booking again.
+	errServiceChanged     = errors.New("the service of a reservation cannot be changed")
+	errCoiffeurOtherSalon = errors.New("coiffeur works in another salon")
 )
 
+// server holds the stores the handlers work on. main wires it to the database;
+// newMemoryStore gives a server that needs no database.
+type server struct {
+	clients      ClientStore
+	salons       SalonStore
+	coiffeurs    CoiffeurStore
+	services     ServiceStore
+	horaires     HoraireStore
+	creneaux     CreneauStore
+	reservations ReservationStore
+	sessions     SessionStore
+
+	config  Config
+	metrics *metrics
+
+	// ready reports whether the dependencies are usable; nil means always.
+	ready func(ctx context.Context) error
+	// draining is set once shutdown starts, so /readyz turns traffic away.
+	draining atomic.Bool
+
+	// generateMu keeps two generation runs from creating the same creneaux.
+	generateMu sync.Mutex
+}
+
+func newServer(store Store, cfg Config) *server {
+	return &server{
+		config:       cfg,
+		metrics:      newMetrics(),
+		clients:      store,
+		salons:       store,
+		coiffeurs:    store,
+		services:     store,
+		horaires:     store,
+		creneaux:     store,
+		reservations: store,
+		sessions:     store,
+	}
+}
+
 // / MAIN
 func main() {
-	/// BASE DE DONNÉES
-	var err error
-	db, err = sql.Open("mysql", "goteam:root@tcp(localhost:3306)/golang")
+	err := run(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-	defer db.Close()
+}
 
-	err = db.Ping()
+// run does the work of main and returns instead of exiting, so that deferred
+// cleanups such as closing the database always happen.
+func run(args []string) error {
+	/// CONFIGURATION
+	cfg, args, err := loadConfig(args)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg.LogLevel))
+	if len(args) > 0 && args[0] == "config" {
+		return runConfigCommand(cfg, args[1:])
 	}
 
-	/// TABLES
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS clients (
-			id_client INT AUTO_INCREMENT PRIMARY KEY,
-			firstname VARCHAR(150),
-			lastname VARCHAR(150),
-			email VARCHAR(150),
-			password VARCHAR(255)
-		);
-    `)
+	ctx, hurry, stop := shutdownSignals()
+	defer stop()
+
+	/// BASE DE DONNÉES
+	db, dbDialect, err = openDB(cfg.Database)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer db.Close()
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS salons (
-			id_salon INT AUTO_INCREMENT PRIMARY KEY,
-			name VARCHAR(150)
-		);
-    `)
+	err = waitForDB(ctx, db, cfg.Database.ConnectTimeout)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS coiffeurs (
-			id_coiffeur INT AUTO_INCREMENT PRIMARY KEY,
-			id_salon INT,
-			firstname VARCHAR(150),
-			lastname VARCHAR(150)
-		);
-    `)
-	if err != nil {
-		log.Fatal(err)
+	/// MIGRATIONS
+	legacyTimezone = cfg.Database.LegacyTimezone
+	if len(args) > 0 && args[0] == "migrate" {
+		return runMigrateCommand(args[1:])
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS creneaux (
-			id_creneau INT AUTO_INCREMENT PRIMARY KEY,
-			id_coiffeur INT,
-			date_creneau VARCHAR(150),
-			availability BOOLEAN
-		);
-    `)
+	err = migrateTo(latestVersion())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS reservations (
-			id_reservation INT AUTO_INCREMENT PRIMARY KEY,
-			id_salon INT,
-			id_coiffeur INT,
-			id_creneau INT
-		);
-    `)
+	/// SERVEUR
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer shutdownTracing(context.Background())
+
+	s := newServer(newSQLStore(db, dbDialect), cfg)
+	s.ready = dbReady
+	s.metrics.watchDB(db, dbDialect.name)
+	return s.serve(ctx, hurry, s.httpServer())
+}
+
+// / ROUTES
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	/// Santé
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.Handle("/metrics", s.metrics.handler())
+
+	// Anything unmatched gets a problem body rather than the mux's text 404.
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		resourceNotFound(w, r, "no route for "+r.URL.Path)
+	})
+
+	/// Auth
+	mux.HandleFunc("/api/auth/login", s.loginHandler)
+	mux.HandleFunc("/api/auth/logout", s.requireAuth(s.logoutHandler))
+	mux.HandleFunc("/api/auth/refresh", s.requireAuth(s.refreshHandler))
 
-	/// ROUTES
 	/// Clients
-	http.HandleFunc("/api/clients", getClientsHandler)
-	http.HandleFunc("/api/clients/add", addClientHandler)
-	http.HandleFunc("/api/clients/update", updateClientHandler)
-	http.HandleFunc("/api/clients/delete", deleteClientHandler)
+	mux.HandleFunc("/api/clients", s.authorize(adminOnly, s.getClientsHandler))
+	if s.config.Features["signup"] {
+		mux.HandleFunc("/api/clients/add", s.addClientHandler)
+	} else {
+		mux.HandleFunc("/api/clients/add", s.authorize(adminOnly, s.addClientHandler))
+	}
+	mux.HandleFunc("/api/clients/update", requireIfMatch(s.authorize(self, s.updateClientHandler)))
+	mux.HandleFunc("/api/clients/delete", requireIfMatch(s.authorize(self, s.deleteClientHandler)))
+	mux.HandleFunc("/api/clients/role", s.authorize(adminOnly, s.updateClientRoleHandler))
+	mux.HandleFunc("/api/clients/reservations/upcoming", s.authorize(self, s.getClientUpcomingReservationsHandler))
+	mux.HandleFunc("/api/clients/reservations/past", s.authorize(self, s.getClientPastReservationsHandler))
 
 	/// Salons
-	http.HandleFunc("/api/salons", getSalonsHandler)
-	http.HandleFunc("/api/salons/add", addSalonHandler)
-	http.HandleFunc("/api/salons/update", updateSalonHandler)
-	http.HandleFunc("/api/salons/delete", deleteSalonHandler)
+	mux.HandleFunc("/api/salons", s.authorize(anyone, s.getSalonsHandler))
+	mux.HandleFunc("/api/salons/add", s.authorize(adminOnly, s.addSalonHandler))
+	mux.HandleFunc("/api/salons/update", requireIfMatch(s.authorize(canManageSalon, s.updateSalonHandler)))
+	mux.HandleFunc("/api/salons/delete", requireIfMatch(s.authorize(canManageSalon, s.deleteSalonHandler)))
 
 	/// Coiffeurs
-	http.HandleFunc("/api/coiffeurs", getCoiffeursHandler)
-	http.HandleFunc("/api/coiffeur/add", addCoiffeurHandler)
-	http.HandleFunc("/api/coiffeur/update", updateCoiffeurHandler)
-	http.HandleFunc("/api/coiffeur/delete", deleteCoiffeurHandler)
+	mux.HandleFunc("/api/coiffeurs", s.authorize(anyone, s.getCoiffeursHandler))
+	mux.HandleFunc("/api/coiffeur/add", s.authorize(s.canManageCoiffeur, s.addCoiffeurHandler))
+	mux.HandleFunc("/api/coiffeur/update", requireIfMatch(s.authorize(s.canManageCoiffeur, s.updateCoiffeurHandler)))
+	mux.HandleFunc("/api/coiffeur/delete", requireIfMatch(s.authorize(s.canManageCoiffeur, s.deleteCoiffeurHandler)))
+
+	/// Services
+	mux.HandleFunc("/api/services", s.authorize(anyone, s.getServicesHandler))
+	mux.HandleFunc("/api/services/add", s.authorize(s.canManageService, s.addServiceHandler))
+	mux.HandleFunc("/api/services/update", requireIfMatch(s.authorize(s.canManageService, s.updateServiceHandler)))
+	mux.HandleFunc("/api/services/delete", requireIfMatch(s.authorize(s.canManageService, s.deleteServiceHandler)))
 
 	/// Creneaux
-	http.HandleFunc("/api/creneaux", getCreneauxHandler)
-	http.HandleFunc("/api/creneaux/add", addCreneauHandler)
-	http.HandleFunc("/api/creneaux/update", updateCreneauHandler)
-	http.HandleFunc("/api/creneaux/delete", deleteCreneauHandler)
+	mux.HandleFunc("/api/creneaux", s.authorize(anyone, s.getCreneauxHandler))
+	mux.HandleFunc("/api/creneaux/add", s.authorize(s.canManageCreneau, s.addCreneauHandler))
+	mux.HandleFunc("/api/creneaux/update", requireIfMatch(s.authorize(s.canManageCreneau, s.updateCreneauHandler)))
+	mux.HandleFunc("/api/creneaux/delete", requireIfMatch(s.authorize(s.canManageCreneau, s.deleteCreneauHandler)))
+	mux.HandleFunc("/api/creneaux/generate", s.authorize(s.canManageCreneau, s.generateCreneauxHandler))
+
+	/// Availability
+	mux.HandleFunc("/api/availability", s.authorize(anyone, s.getAvailabilityHandler))
+
+	/// Horaires
+	mux.HandleFunc("/api/horaires", s.authorize(anyone, s.getHorairesHandler))
+	mux.HandleFunc("/api/horaires/add", s.authorize(s.canManageHoraire, s.addHoraireHandler))
+	mux.HandleFunc("/api/horaires/update", requireIfMatch(s.authorize(s.canManageHoraire, s.updateHoraireHandler)))
+	mux.HandleFunc("/api/horaires/delete", requireIfMatch(s.authorize(s.canManageHoraire, s.deleteHoraireHandler)))
 
 	/// Reservations
-	http.HandleFunc("/api/reservations", getReservationsHandler)
-	http.HandleFunc("/api/reservations/add", addReservationHandler)
-	http.HandleFunc("/api/reservations/update", updateReservationHandler)
-	http.HandleFunc("/api/reservations/delete", deleteReservationHandler)
+	mux.HandleFunc("/api/reservations", s.authorize(adminOnly, s.getReservationsHandler))
+	mux.HandleFunc("/api/reservations/add", s.authorize(canBook, s.addReservationHandler))
+	mux.HandleFunc("/api/reservations/update", requireIfMatch(s.authorize(s.canManageReservation, s.updateReservationHandler)))
+	mux.HandleFunc("/api/reservations/delete", requireIfMatch(s.authorize(s.canManageReservation, s.deleteReservationHandler)))
 
-	port := 8080
-	fmt.Printf("Server is running on port %d...\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	s.routesV2(mux)
+
+	return traceRequests(mux, s.logRequests(mux, s.cors(s.metrics.instrument(mux))))
+}
+
+// writeStoreError answers a failed store call. A missing row gives 404.
+// Foreign key violations are the client's doing: a parent still referenced
+// gives 409, a reference to a missing row gives 400. A write based on an old
+// version gives 412. Anything else is logged as a 500.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case errNotFound:
+		resourceNotFound(w, r, "the resource does not exist")
+	case errReferenced:
+		writeProblem(w, r, http.StatusConflict, "referenced", "the resource is still referenced by other resources")
+	case errMissingReference:
+		badRequest(w, r, "missing_reference", "a resource referenced by the request does not exist")
+	case errDuplicate:
+		writeProblem(w, r, http.StatusConflict, "duplicate", "a resource with the same key already exists")
+	case errVersionMismatch:
+		preconditionFailed(w, r)
+	default:
+		internalError(w, r, err)
+	}
 }
 
 // CLIENTS
-func addClientHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addClientHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var newClient Client
 	err := json.NewDecoder(r.Body).Decode(&newClient)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidBody(w, r, err)
+		return
+	}
+	if !s.valid(w, r, newClient, onCreate) {
 		return
 	}
 
-	result, err := db.Exec("INSERT INTO clients (firstname, lastname, email, password) VALUES (?, ?, ?, ?)", newClient.Firstname, newClient.Lastname, newClient.Email, newClient.Password)
+	hash, err := hashPassword(newClient.Password)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
-	id, err := result.LastInsertId()
+	newClient.Password = hash
+	err = s.clients.AddClient(r.Context(), &newClient)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeClientError(w, r, err)
 		return
 	}
 
-	newClient.ID_client = int(id)
-
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newClient)
 }
 
-func getClientsHandler(w http.ResponseWriter, r *http.Request) {
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
+// writeClientError answers a failed client write. Emails are unique, so a
+// duplicate is a taken email.
+func writeClientError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == errDuplicate {
+		writeProblem(w, r, http.StatusConflict, "email_taken", "another account already uses this email")
+		return
+	}
+	writeStoreError(w, r, err)
+}
 
-	// Fetch users from the database
-	rows, err := db.Query("SELECT * FROM clients")
+func (s *server) getClientsHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := parseListOptions(w, r, clientSorts)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := ClientFilter{Role: query.Get("role"), Email: query.Get("email")}
+	if filter.Role != "" && !validRole(filter.Role) {
+		invalidParam(w, r, "role")
+		return
+	}
+
+	page, err := s.clients.ListClients(r.Context(), filter, o)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var clientList []Client
-	for rows.Next() {
-		var client Client
-		err := rows.Scan(&client.ID_client, &client.Firstname, &client.Lastname, &client.Email, &client.Password)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		clientList = append(clientList, client)
+	writePage(w, r, page, clientID, clientSorts, o)
+}
+
+func (s *server) getClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_client"))
+	if err != nil {
+		invalidParam(w, r, "id_client")
+		return
+	}
+	expand, ok := parseExpand(w, r, "salon", "coiffeur")
+	if !ok {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clientList)
+	client, err := s.clients.GetClient(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(expand) == 0 && notModified(w, r, client.Version) {
+		return
+	}
+
+	var expansions []expansion
+	if expand["salon"] {
+		var salon any
+		if client.ID_salon != 0 {
+			salon, err = related(s.salons.GetSalon(r.Context(), client.ID_salon))
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+		}
+		expansions = append(expansions, expansion{"salon", salon})
+	}
+	if expand["coiffeur"] {
+		var coiffeur any
+		if client.ID_coiffeur != 0 {
+			coiffeur, err = related(s.coiffeurs.GetCoiffeur(r.Context(), client.ID_coiffeur))
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+		}
+		expansions = append(expansions, expansion{"coiffeur", coiffeur})
+	}
+	writeExpanded(w, r, client, expansions)
 }
 
-func updateClientHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (s *server) updateClientHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
 	var updatedClient Client
-	err := json.NewDecoder(r.Body).Decode(&updatedClient)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeUpdate(w, r, &updatedClient, "id_client", s.clients.GetClient) {
+		return
+	}
+	updatedClient.Version = version
+	if !s.valid(w, r, updatedClient, onUpdate) {
 		return
 	}
 
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-	row := db.QueryRow("SELECT id_client FROM clients WHERE id_client=?", updatedClient.ID_client)
-	if err := row.Scan(&updatedClient.ID_client); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
+	// An empty password keeps the current one.
+	if updatedClient.Password != "" {
+		hash, err := hashPassword(updatedClient.Password)
+		if err != nil {
+			internalError(w, r, err)
 			return
 		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		updatedClient.Password = hash
 	}
 
-	_, err = db.Exec("UPDATE clients SET firstname=?, lastname=?, email=?, password=? WHERE id_client=?", updatedClient.Firstname, updatedClient.Lastname, updatedClient.Email, updatedClient.Password, updatedClient.ID_client)
+	err := s.clients.UpdateClient(r.Context(), &updatedClient)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeClientError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(updatedClient.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(updatedClient)
 }
 
-func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteClientHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	idParam := r.URL.Query().Get("id_client")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidParam(w, r, "id_client")
 		return
 	}
-
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-	row := db.QueryRow("SELECT id_client FROM clients WHERE id_client=?", id)
-	if err := row.Scan(&id); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM clients WHERE id_client=?", id)
+	err = s.clients.DeleteClient(r.Context(), id, version)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// updateClientRoleHandler changes the role of an account and the salon or
+// coiffeur it is attached to. Sign-up always creates plain clients, so staff
+// accounts are promoted here by an admin.
+func (s *server) updateClientRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var updatedClient Client
+	err := json.NewDecoder(r.Body).Decode(&updatedClient)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	if !validRole(updatedClient.Role) {
+		invalidFields(w, r, FieldError{Field: "role", Code: "invalid", Message: "must be client, coiffeur, manager or admin"})
+		return
+	}
+
+	var idSalon, idCoiffeur int
+	switch updatedClient.Role {
+	case RoleManager:
+		idSalon = updatedClient.ID_salon
+	case RoleCoiffeur:
+		idCoiffeur = updatedClient.ID_coiffeur
+	}
+
+	err = s.clients.SetClientRole(r.Context(), updatedClient.ID_client, updatedClient.Role, idSalon, idCoiffeur)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // SALONS
-func addSalonHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addSalonHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var newSalon Salon
 	err := json.NewDecoder(r.Body).Decode(&newSalon)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidBody(w, r, err)
 		return
 	}
-
-	result, err := db.Exec("INSERT INTO salons (name) VALUES (?)", newSalon.Name)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if newSalon.Timezone == "" {
+		newSalon.Timezone = defaultTimezone
+	}
+	if !s.valid(w, r, newSalon, onCreate) {
 		return
 	}
 
-	id, err := result.LastInsertId()
+	err = s.salons.AddSalon(r.Context(), &newSalon)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	newSalon.ID_salon = int(id)
-
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newSalon)
 }
 
-func getSalonsHandler(w http.ResponseWriter, r *http.Request) {
-	salonsMu.RLock()
-	defer salonsMu.RUnlock()
+func (s *server) getSalonsHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := parseListOptions(w, r, salonSorts)
+	if !ok {
+		return
+	}
+
+	page, err := s.salons.ListSalons(r.Context(), SalonFilter{Name: r.URL.Query().Get("name")}, o)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
 
-	// Fetch users from the database
-	rows, err := db.Query("SELECT * FROM salons")
+	writePage(w, r, page, salonID, salonSorts, o)
+}
+
+func (s *server) getSalonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_salon"))
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		invalidParam(w, r, "id_salon")
+		return
+	}
+	expand, ok := parseExpand(w, r, "coiffeurs", "services")
+	if !ok {
 		return
 	}
-	defer rows.Close()
 
-	var salonList []Salon
-	for rows.Next() {
-		var salon Salon
-		err := rows.Scan(&salon.ID_salon, &salon.Name)
+	salon, err := s.salons.GetSalon(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(expand) == 0 && notModified(w, r, salon.Version) {
+		return
+	}
+
+	var expansions []expansion
+	if expand["coiffeurs"] {
+		page, err := s.coiffeurs.ListCoiffeurs(r.Context(), CoiffeurFilter{ID_salon: id}, ListOptions{Sort: "id"})
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			internalError(w, r, err)
 			return
 		}
-		salonList = append(salonList, salon)
+		expansions = append(expansions, expansion{"coiffeurs", append([]Coiffeur{}, page.Items...)})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(salonList)
+	if expand["services"] {
+		serviceList, err := s.services.ListServices(r.Context(), id)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		expansions = append(expansions, expansion{"services", append([]Service{}, serviceList...)})
+	}
+	writeExpanded(w, r, salon, expansions)
 }
 
-func updateSalonHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (s *server) updateSalonHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
 		return
 	}
 
-	var updatedSalon Salon
-	err := json.NewDecoder(r.Body).Decode(&updatedSalon)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	salonsMu.RLock()
-	defer salonsMu.RUnlock()
-	row := db.QueryRow("SELECT id_salon FROM salons WHERE id_salon=?", updatedSalon.ID_salon)
-	if err := row.Scan(&updatedSalon.ID_salon); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	var updatedSalon Salon
+	if !decodeUpdate(w, r, &updatedSalon, "id_salon", s.salons.GetSalon) {
+		return
+	}
+	updatedSalon.Version = version
+	if updatedSalon.Timezone == "" {
+		updatedSalon.Timezone = defaultTimezone
+	}
+	if !s.valid(w, r, updatedSalon, onUpdate) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE salons SET name=? WHERE id_salon=?", updatedSalon.Name, updatedSalon.ID_salon)
+	err := s.salons.UpdateSalon(r.Context(), &updatedSalon)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(updatedSalon.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(updatedSalon)
 }
 
-func deleteSalonHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteSalonHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	idParam := r.URL.Query().Get("id_salon")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidParam(w, r, "id_salon")
 		return
 	}
-
-	salonsMu.Lock()
-	defer salonsMu.Unlock()
-	row := db.QueryRow("SELECT id_salon FROM salons WHERE id_salon=?", id)
-	if err := row.Scan(&id); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM salons WHERE id_salon=?", id)
+	err = s.salons.DeleteSalon(r.Context(), id, version)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
@@ -441,135 +659,177 @@ func deleteSalonHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // COIFFEURS
-func addCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var newCoiffeur Coiffeur
 	err := json.NewDecoder(r.Body).Decode(&newCoiffeur)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidBody(w, r, err)
 		return
 	}
 
-	result, err := db.Exec("INSERT INTO coiffeurs (id_salon, firstname, lastname) VALUES (?, ?, ?)", newCoiffeur.ID_coiffeur, newCoiffeur.Firstname, newCoiffeur.Lastname)
+	servicesErrors, err := s.servicesFieldErrors(r.Context(), newCoiffeur.ID_salon, newCoiffeur.Services)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
+		return
+	}
+	if !s.valid(w, r, newCoiffeur, onCreate, servicesErrors...) {
 		return
 	}
 
-	id, err := result.LastInsertId()
+	err = s.coiffeurs.AddCoiffeur(r.Context(), &newCoiffeur)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	newCoiffeur.ID_coiffeur = int(id)
-
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newCoiffeur)
 }
 
-func getCoiffeursHandler(w http.ResponseWriter, r *http.Request) {
-	coiffeursMu.RLock()
-	defer coiffeursMu.RUnlock()
+func (s *server) getCoiffeursHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := parseListOptions(w, r, coiffeurSorts)
+	if !ok {
+		return
+	}
+	var filter CoiffeurFilter
+	if filter.ID_salon, ok = queryInt(w, r, "id_salon"); !ok {
+		return
+	}
+	if filter.ID_service, ok = queryInt(w, r, "id_service"); !ok {
+		return
+	}
 
-	// Fetch users from the database
-	rows, err := db.Query("SELECT * FROM coiffeurs")
+	page, err := s.coiffeurs.ListCoiffeurs(r.Context(), filter, o)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var coiffeurList []Coiffeur
-	for rows.Next() {
-		var coiffeur Coiffeur
-		err := rows.Scan(&coiffeur.ID_coiffeur, &coiffeur.ID_salon, &coiffeur.Firstname, &coiffeur.Lastname)
+	writePage(w, r, page, coiffeurID, coiffeurSorts, o)
+}
+
+func (s *server) getCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_coiffeur"))
+	if err != nil {
+		invalidParam(w, r, "id_coiffeur")
+		return
+	}
+	// The services field already holds the ids, so the objects get their
+	// own name.
+	expand, ok := parseExpand(w, r, "salon", "service_details")
+	if !ok {
+		return
+	}
+
+	coiffeur, err := s.coiffeurs.GetCoiffeur(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(expand) == 0 && notModified(w, r, coiffeur.Version) {
+		return
+	}
+
+	var expansions []expansion
+	if expand["salon"] {
+		salon, err := related(s.salons.GetSalon(r.Context(), coiffeur.ID_salon))
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			internalError(w, r, err)
 			return
 		}
-		coiffeurList = append(coiffeurList, coiffeur)
+		expansions = append(expansions, expansion{"salon", salon})
+	}
+	if expand["service_details"] {
+		serviceList := []Service{}
+		for _, idService := range coiffeur.Services {
+			service, err := s.services.GetService(r.Context(), idService)
+			if err == errNotFound {
+				continue
+			}
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			serviceList = append(serviceList, service)
+		}
+		expansions = append(expansions, expansion{"service_details", serviceList})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(coiffeurList)
+	writeExpanded(w, r, coiffeur, expansions)
 }
 
-func updateCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (s *server) updateCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
 	var updatedCoiffeur Coiffeur
-	err := json.NewDecoder(r.Body).Decode(&updatedCoiffeur)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeUpdate(w, r, &updatedCoiffeur, "id_coiffeur", s.coiffeurs.GetCoiffeur) {
 		return
 	}
+	updatedCoiffeur.Version = version
 
-	coiffeursMu.RLock()
-	defer coiffeursMu.RUnlock()
-	row := db.QueryRow("SELECT id_coiffeur FROM coiffeurs WHERE id_coiffeur=?", updatedCoiffeur.ID_coiffeur)
-	if err := row.Scan(&updatedCoiffeur.ID_coiffeur); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// A PUT without a services list keeps the current services. A PATCH
+	// carries them over unless it sets them to null, which clears them.
+	if r.Method == http.MethodPatch && updatedCoiffeur.Services == nil {
+		updatedCoiffeur.Services = []int{}
+	}
+	servicesErrors, err := s.servicesFieldErrors(r.Context(), updatedCoiffeur.ID_salon, updatedCoiffeur.Services)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !s.valid(w, r, updatedCoiffeur, onUpdate, servicesErrors...) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE coiffeurs SET id_salon=?, firstname=?, lastname=? WHERE id_coiffeur=?", updatedCoiffeur.ID_salon, updatedCoiffeur.Firstname, updatedCoiffeur.Lastname, updatedCoiffeur.ID_coiffeur)
+	err = s.coiffeurs.UpdateCoiffeur(r.Context(), &updatedCoiffeur)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
+		return
+	}
+	// The store dedupes the services and may have kept the current ones.
+	storedCoiffeur, err := s.coiffeurs.GetCoiffeur(r.Context(), updatedCoiffeur.ID_coiffeur)
+	if err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(storedCoiffeur.Version))
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(updatedCoiffeur)
+	json.NewEncoder(w).Encode(storedCoiffeur)
 }
 
-func deleteCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	idParam := r.URL.Query().Get("id_coiffeur")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidParam(w, r, "id_coiffeur")
 		return
 	}
-
-	coiffeursMu.Lock()
-	defer coiffeursMu.Unlock()
-	row := db.QueryRow("SELECT id_coiffeur FROM coiffeurs WHERE id_coiffeur=?", id)
-	if err := row.Scan(&id); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM coiffeurs WHERE id_coiffeur=?", id)
+	err = s.coiffeurs.DeleteCoiffeur(r.Context(), id, version)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
@@ -577,135 +837,189 @@ func deleteCoiffeurHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CRENEAU
-func addCreneauHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addCreneauHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var newCreneau Creneau
 	err := json.NewDecoder(r.Body).Decode(&newCreneau)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidBody(w, r, err)
 		return
 	}
+	newCreneau.defaultEnd()
+	if !s.valid(w, r, newCreneau, onCreate) {
+		return
+	}
+	newCreneau.Start = newCreneau.Start.UTC()
+	newCreneau.End = newCreneau.End.UTC()
 
-	result, err := db.Exec("INSERT INTO creneaux (id_coiffeur, date_creneau, availability) VALUES (?, ?, ?)", newCreneau.ID_coiffeur, newCreneau.Date, newCreneau.Availability)
+	loc, err := s.coiffeurLocation(r.Context(), newCreneau.ID_coiffeur)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
-	id, err := result.LastInsertId()
+	err = s.creneaux.AddCreneau(r.Context(), &newCreneau)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	newCreneau.ID_creneau = int(id)
+	newCreneau.Start = newCreneau.Start.In(loc)
+	newCreneau.End = newCreneau.End.In(loc)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newCreneau)
 }
 
-func getCreneauxHandler(w http.ResponseWriter, r *http.Request) {
-	creneauxMu.RLock()
-	defer creneauxMu.RUnlock()
+func (s *server) getCreneauxHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := parseListOptions(w, r, creneauSorts)
+	if !ok {
+		return
+	}
+	var filter CreneauFilter
+	if filter.ID_coiffeur, ok = queryInt(w, r, "id_coiffeur"); !ok {
+		return
+	}
+	if filter.From, ok = queryTime(w, r, "from"); !ok {
+		return
+	}
+	if filter.To, ok = queryTime(w, r, "to"); !ok {
+		return
+	}
+	if filter.Available, ok = queryBool(w, r, "available"); !ok {
+		return
+	}
 
-	// Fetch users from the database
-	rows, err := db.Query("SELECT * FROM creneaux")
+	page, err := s.creneaux.ListCreneaux(r.Context(), filter, o)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var creneauList []Creneau
-	for rows.Next() {
-		var creneau Creneau
-		err := rows.Scan(&creneau.ID_creneau, &creneau.ID_coiffeur, &creneau.Date, &creneau.Availability)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+	locations := map[int]*time.Location{}
+	for i, creneau := range page.Items {
+		loc, ok := locations[creneau.ID_coiffeur]
+		if !ok {
+			loc, err = s.coiffeurLocation(r.Context(), creneau.ID_coiffeur)
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			locations[creneau.ID_coiffeur] = loc
 		}
-		creneauList = append(creneauList, creneau)
+		page.Items[i].Start = creneau.Start.In(loc)
+		page.Items[i].End = creneau.End.In(loc)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(creneauList)
+	writePage(w, r, page, creneauID, creneauSorts, o)
 }
 
-func updateCreneauHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (s *server) getCreneauHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_creneau"))
+	if err != nil {
+		invalidParam(w, r, "id_creneau")
+		return
+	}
+	expand, ok := parseExpand(w, r, "coiffeur")
+	if !ok {
 		return
 	}
 
-	var updatedCreneau Creneau
-	err := json.NewDecoder(r.Body).Decode(&updatedCreneau)
+	creneau, err := s.creneaux.GetCreneau(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(expand) == 0 && notModified(w, r, creneau.Version) {
+		return
+	}
+	loc, err := s.coiffeurLocation(r.Context(), creneau.ID_coiffeur)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		internalError(w, r, err)
 		return
 	}
+	creneau.Start = creneau.Start.In(loc)
+	creneau.End = creneau.End.In(loc)
 
-	creneauxMu.RLock()
-	defer creneauxMu.RUnlock()
-	row := db.QueryRow("SELECT id_creneau FROM creneaux WHERE id_creneau=?", updatedCreneau.ID_creneau)
-	if err := row.Scan(&updatedCreneau.ID_creneau); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
+	var expansions []expansion
+	if expand["coiffeur"] {
+		coiffeur, err := related(s.coiffeurs.GetCoiffeur(r.Context(), creneau.ID_coiffeur))
+		if err != nil {
+			internalError(w, r, err)
 			return
 		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		expansions = append(expansions, expansion{"coiffeur", coiffeur})
+	}
+	writeExpanded(w, r, creneau, expansions)
+}
+
+func (s *server) updateCreneauHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updatedCreneau Creneau
+	if !decodeUpdate(w, r, &updatedCreneau, "id_creneau", s.creneaux.GetCreneau) {
 		return
 	}
+	updatedCreneau.Version = version
+	updatedCreneau.defaultEnd()
+	if !s.valid(w, r, updatedCreneau, onUpdate) {
+		return
+	}
+	updatedCreneau.Start = updatedCreneau.Start.UTC()
+	updatedCreneau.End = updatedCreneau.End.UTC()
 
-	_, err = db.Exec("UPDATE creneaux SET id_coiffeur=?, date=?, availability=? WHERE id_creneau=?", updatedCreneau.ID_coiffeur, updatedCreneau.Date, updatedCreneau.Availability, updatedCreneau.ID_creneau)
+	loc, err := s.coiffeurLocation(r.Context(), updatedCreneau.ID_coiffeur)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
+	err = s.creneaux.UpdateCreneau(r.Context(), &updatedCreneau)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	updatedCreneau.Start = updatedCreneau.Start.In(loc)
+	updatedCreneau.End = updatedCreneau.End.In(loc)
+
+	w.Header().Set("ETag", etag(updatedCreneau.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(updatedCreneau)
 }
 
-func deleteCreneauHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteCreneauHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	idParam := r.URL.Query().Get("id_creneau")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidParam(w, r, "id_creneau")
 		return
 	}
-
-	creneauxMu.Lock()
-	defer creneauxMu.Unlock()
-	row := db.QueryRow("SELECT id_creneau FROM creneaux WHERE id_creneau=?", id)
-	if err := row.Scan(&id); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM creneaux WHERE id_creneau=?", id)
+	err = s.creneaux.DeleteCreneau(r.Context(), id, version)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
@@ -713,139 +1027,247 @@ func deleteCreneauHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // RESERVATION
-func addReservationHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) addReservationHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var newReservation Reservation
 	err := json.NewDecoder(r.Body).Decode(&newReservation)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidBody(w, r, err)
 		return
 	}
-
-	result, err := db.Exec("INSERT INTO reservations (id_salon, id_coiffeur, id_creneau) VALUES (?, ?, ?)", newReservation.ID_salon, newReservation.ID_coiffeur, newReservation.ID_creneau)
-	_, err = db.Exec("UPDATE creneaux SET availability=false WHERE id_creneau=?", newReservation.ID_creneau)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if !s.valid(w, r, newReservation, onCreate) {
 		return
 	}
 
-	id, err := result.LastInsertId()
+	newReservation, err = s.reservations.BookReservation(r.Context(), newReservation)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.writeBookingError(w, r, err)
 		return
 	}
-
-	newReservation.ID_reservation = int(id)
+	s.metrics.reservationsCreated.Inc()
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newReservation)
 }
 
-func getReservationsHandler(w http.ResponseWriter, r *http.Request) {
-	reservationsMu.RLock()
-	defer reservationsMu.RUnlock()
+// writeBookingError answers a failed booking.
+func (s *server) writeBookingError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case errClientNotFound, errCreneauNotFound, errServiceNotFound:
+		resourceNotFound(w, r, err.Error())
+	case errServiceNotOffered:
+		badRequest(w, r, "service_not_offered", err.Error())
+	case errCreneauOtherCoiffeur:
+		badRequest(w, r, "creneau_other_coiffeur", err.Error())
+	case errCoiffeurOtherSalon:
+		badRequest(w, r, "coiffeur_other_salon", err.Error())
+	case errServiceChanged:
+		writeProblem(w, r, http.StatusConflict, "service_changed", err.Error())
+	case errCreneauUnavailable:
+		s.metrics.bookingConflicts.Inc()
+		writeProblem(w, r, http.StatusConflict, "creneau_unavailable", err.Error())
+	case errCreneauTooShort:
+		writeProblem(w, r, http.StatusConflict, "creneau_too_short", err.Error())
+	default:
+		writeStoreError(w, r, err)
+	}
+}
+
+func (s *server) getReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	o, ok := parseListOptions(w, r, reservationSorts)
+	if !ok {
+		return
+	}
+	filter := ReservationFilter{Status: r.URL.Query().Get("status"), Now: time.Now()}
+	if filter.Status != "" && filter.Status != statusUpcoming && filter.Status != statusPast {
+		invalidParam(w, r, "status")
+		return
+	}
+	if filter.ID_client, ok = queryInt(w, r, "id_client"); !ok {
+		return
+	}
+	if filter.ID_salon, ok = queryInt(w, r, "id_salon"); !ok {
+		return
+	}
+	if filter.ID_coiffeur, ok = queryInt(w, r, "id_coiffeur"); !ok {
+		return
+	}
+
+	page, err := s.reservations.ListReservations(r.Context(), filter, o)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
 
-	// Fetch users from the database
-	rows, err := db.Query("SELECT * FROM reservations")
+	writePage(w, r, page, reservationID, reservationSorts, o)
+}
+
+func (s *server) getReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_reservation"))
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		invalidParam(w, r, "id_reservation")
+		return
+	}
+	expand, ok := parseExpand(w, r, "client", "salon", "coiffeur", "creneau", "service")
+	if !ok {
 		return
 	}
-	defer rows.Close()
 
-	var reservationList []Reservation
-	for rows.Next() {
-		var reservation Reservation
-		err := rows.Scan(&reservation.ID_reservation, &reservation.ID_salon, &reservation.ID_coiffeur, &reservation.ID_creneau)
+	reservation, err := s.reservations.GetReservation(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(expand) == 0 && notModified(w, r, reservation.Version) {
+		return
+	}
+
+	ctx := r.Context()
+	lookups := []struct {
+		name string
+		get  func() (any, error)
+	}{
+		{"client", func() (any, error) { return related(s.clients.GetClient(ctx, reservation.ID_client)) }},
+		{"salon", func() (any, error) { return related(s.salons.GetSalon(ctx, reservation.ID_salon)) }},
+		{"coiffeur", func() (any, error) { return related(s.coiffeurs.GetCoiffeur(ctx, reservation.ID_coiffeur)) }},
+		{"creneau", func() (any, error) {
+			creneau, err := s.creneaux.GetCreneau(ctx, reservation.ID_creneau)
+			if err != nil {
+				return related(creneau, err)
+			}
+			loc, err := s.coiffeurLocation(ctx, creneau.ID_coiffeur)
+			if err != nil {
+				return nil, err
+			}
+			creneau.Start = creneau.Start.In(loc)
+			creneau.End = creneau.End.In(loc)
+			return creneau, nil
+		}},
+		{"service", func() (any, error) { return related(s.services.GetService(ctx, reservation.ID_service)) }},
+	}
+
+	var expansions []expansion
+	for _, lookup := range lookups {
+		if !expand[lookup.name] {
+			continue
+		}
+		value, err := lookup.get()
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			internalError(w, r, err)
 			return
 		}
-		reservationList = append(reservationList, reservation)
+		expansions = append(expansions, expansion{lookup.name, value})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(reservationList)
+	writeExpanded(w, r, reservation, expansions)
 }
 
-func updateReservationHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (s *server) updateReservationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
 		return
 	}
 
-	var updatedReservation Reservation
-	err := json.NewDecoder(r.Body).Decode(&updatedReservation)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	creneauxMu.RLock()
-	defer creneauxMu.RUnlock()
-	row := db.QueryRow("SELECT id_reservation FROM reservations WHERE id_reservation=?", updatedReservation.ID_reservation)
-	if err := row.Scan(&updatedReservation.ID_reservation); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	var updatedReservation Reservation
+	if !decodeUpdate(w, r, &updatedReservation, "id_reservation", s.reservations.GetReservation) {
+		return
+	}
+	updatedReservation.Version = version
+	if !s.valid(w, r, updatedReservation, onUpdate) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE reservations SET id_salon=?, id_coiffeur=?, id_creneau=? WHERE id_reservation=?", updatedReservation.ID_salon, updatedReservation.ID_coiffeur, updatedReservation.ID_creneau, updatedReservation.ID_reservation)
+	err := s.reservations.UpdateReservation(r.Context(), &updatedReservation)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.writeBookingError(w, r, err)
+		return
+	}
+	// Duration and price come from the service, whatever the body said.
+	storedReservation, err := s.reservations.GetReservation(r.Context(), updatedReservation.ID_reservation)
+	if err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(storedReservation.Version))
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(updatedReservation)
+	json.NewEncoder(w).Encode(storedReservation)
 }
 
-func deleteReservationHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteReservationHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	idParam := r.URL.Query().Get("id_reservation")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		invalidParam(w, r, "id_reservation")
 		return
 	}
-
-	reservationsMu.Lock()
-	defer reservationsMu.Unlock()
-	row := db.QueryRow("SELECT id_reservation FROM reservations WHERE id_reservation=?", id)
-	if err := row.Scan(&id); err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM reservations WHERE id_reservation=?", id)
+	err = s.reservations.DeleteReservation(r.Context(), id, version)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+	s.metrics.reservationsCancelled.Inc()
 
 	w.WriteHeader(http.StatusOK)
 
 }
+
+func (s *server) getClientUpcomingReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	s.getClientReservations(w, r, true)
+}
+
+func (s *server) getClientPastReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	s.getClientReservations(w, r, false)
+}
+
+// getClientReservations lists the reservations of the client given by
+// id_client whose creneau starts from now on (upcoming) or before now.
+func (s *server) getClientReservations(w http.ResponseWriter, r *http.Request, upcoming bool) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	idParam := r.URL.Query().Get("id_client")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		invalidParam(w, r, "id_client")
+		return
+	}
+
+	_, err = s.clients.GetClient(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	reservationList, err := s.reservations.ListClientReservations(r.Context(), id, time.Now(), upcoming)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if reservationList == nil {
+		reservationList = []Reservation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservationList)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(newLogger(io.Discard, "error"))
+	os.Exit(m.Run())
+}
+
+// testBackends lists the stores the API tests run against. SQLite runs on a
+// temporary file; MySQL and PostgreSQL run when TEST_MYSQL_DSN or
+// TEST_POSTGRES_DSN name a database, which the tests empty and migrate.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+	{"sqlite", func(t *testing.T) Store {
+		return openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	}},
+	{"mysql", func(t *testing.T) Store { return openTestDB(t, "mysql", os.Getenv("TEST_MYSQL_DSN")) }},
+	{"postgres", func(t *testing.T) Store { return openTestDB(t, "postgres", os.Getenv("TEST_POSTGRES_DSN")) }},
+}
+
+// openTestDB connects to a database and rebuilds its schema from scratch.
+// Migrations work on the global connection, so it is set for the test.
+func openTestDB(t *testing.T, driver, dsn string) Store {
+	t.Helper()
+	if dsn == "" {
+		t.Skipf("no %s database configured", driver)
+	}
+	cfg := defaultConfig().Database
+	cfg.Driver = driver
+	cfg.DSN = dsn
+
+	conn, d, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, dbDialect = conn, d
+	t.Cleanup(func() {
+		conn.Close()
+		db, dbDialect = nil, nil
+	})
+
+	if err := migrateTo(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTo(latestVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return newSQLStore(conn, d)
+}
+
+// eachBackend runs test once per backend, each time on an empty store behind
+// a real HTTP server.
+func eachBackend(t *testing.T, test func(t *testing.T, api *testAPI)) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, newTestAPI(t, backend.open(t)))
+		})
+	}
+}
+
+const testPassword = "password1"
+
+// testPasswordHash is computed once at the lowest cost, which keeps
+// creating accounts cheap.
+var testPasswordHash = sync.OnceValue(func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+})
+
+// testAPI is a server under test with an admin session.
+type testAPI struct {
+	t     *testing.T
+	url   string
+	store Store
+	admin string
+	seq   int
+}
+
+func newTestAPI(t *testing.T, store Store) *testAPI {
+	server := httptest.NewServer(newServer(store, defaultConfig()).routes())
+	t.Cleanup(server.Close)
+
+	api := &testAPI{t: t, url: server.URL, store: store}
+	api.admin = api.account(RoleAdmin, 0, 0).token
+	return api
+}
+
+// testAccount is an account with an open session.
+type testAccount struct {
+	Client
+	token string
+}
+
+// account creates an account with the given role and logs it in.
+func (a *testAPI) account(role string, idSalon, idCoiffeur int) testAccount {
+	a.t.Helper()
+	a.seq++
+	client := Client{
+		Firstname: "Test",
+		Lastname:  role,
+		Email:     fmt.Sprintf("%s%d@example.com", role, a.seq),
+		Password:  testPasswordHash(),
+	}
+	ctx := context.Background()
+	if err := a.store.AddClient(ctx, &client); err != nil {
+		a.t.Fatal(err)
+	}
+	if role != RoleClient {
+		if err := a.store.SetClientRole(ctx, client.ID_client, role, idSalon, idCoiffeur); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+
+	var session Session
+	a.do("POST", "/api/auth/login", "", Credentials{Email: client.Email, Password: testPassword}).
+		expect(a.t, http.StatusOK).decode(a.t, &session)
+	client, _ = a.store.GetClient(ctx, client.ID_client)
+	return testAccount{Client: client, token: session.Token}
+}
+
+type testResponse struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+// do sends a request with token as bearer, if any. body is sent as is when
+// it is a string and as JSON otherwise; header holds name, value pairs.
+func (a *testAPI) do(method, path, token string, body any, header ...string) testResponse {
+	a.t.Helper()
+	r, err := a.send(method, path, token, body, header...)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return r
+}
+
+// send is do for goroutines other than the test's, which cannot stop it.
+func (a *testAPI) send(method, path, token string, body any, header ...string) (testResponse, error) {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return testResponse{}, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, a.url+path, reader)
+	if err != nil {
+		return testResponse{}, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return testResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return testResponse{Code: resp.StatusCode, Header: resp.Header, Body: data}, err
+}
+
+func (r testResponse) expect(t *testing.T, code int) testResponse {
+	t.Helper()
+	if r.Code != code {
+		t.Fatalf("status %d, want %d: %s", r.Code, code, r.Body)
+	}
+	return r
+}
+
+func (r testResponse) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("%v: %s", err, r.Body)
+	}
+}
+
+// problem returns the code of a problem details body.
+func (r testResponse) problem(t *testing.T) string {
+	t.Helper()
+	var problem Problem
+	r.decode(t, &problem)
+	return problem.Code
+}
+
+// FIXTURES
+// create posts body as the admin and decodes the created resource into v.
+func (a *testAPI) create(path string, body, v any) {
+	a.t.Helper()
+	a.do("POST", path, a.admin, body).expect(a.t, http.StatusCreated).decode(a.t, v)
+}
+
+func (a *testAPI) salon() Salon {
+	a.t.Helper()
+	var salon Salon
+	a.create("/api/v2/salons", Salon{Name: "Salon", Timezone: "Europe/Paris"}, &salon)
+	return salon
+}
+
+func (a *testAPI) service(idSalon, minutes int) Service {
+	a.t.Helper()
+	var service Service
+	a.create("/api/v2/services", Service{ID_salon: idSalon, Name: "Coupe", Duration: minutes, Price: 25}, &service)
+	return service
+}
+
+func (a *testAPI) coiffeur(idSalon int, services ...int) Coiffeur {
+	a.t.Helper()
+	var coiffeur Coiffeur
+	a.create("/api/v2/coiffeurs", Coiffeur{ID_salon: idSalon, Firstname: "Camille", Lastname: "Martin", Services: services}, &coiffeur)
+	return coiffeur
+}
+
+func (a *testAPI) creneau(idCoiffeur int, start time.Time, minutes int) Creneau {
+	a.t.Helper()
+	var creneau Creneau
+	a.create("/api/v2/creneaux", Creneau{ID_coiffeur: idCoiffeur, Start: start, End: start.Add(time.Duration(minutes) * time.Minute), Availability: true}, &creneau)
+	return creneau
+}
+
+// testStart is a slot start in the future, on the hour.
+func testStart() time.Time {
+	return time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+}
+
+// bookable is a salon with one coiffeur performing one service, and the
+// pieces needed to book it.
+type bookable struct {
+	salon    Salon
+	service  Service
+	coiffeur Coiffeur
+}
+
+func (a *testAPI) bookable(minutes int) bookable {
+	a.t.Helper()
+	salon := a.salon()
+	service := a.service(salon.ID_salon, minutes)
+	return bookable{salon: salon, service: service, coiffeur: a.coiffeur(salon.ID_salon, service.ID_service)}
+}
+
+func (b bookable) reservation(idClient, idCreneau int) Reservation {
+	return Reservation{ID_client: idClient, ID_salon: b.salon.ID_salon, ID_coiffeur: b.coiffeur.ID_coiffeur, ID_creneau: idCreneau, ID_service: b.service.ID_service}
+}
+
+func pathf(format string, ids ...int) string {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = strconv.Itoa(id)
+	}
+	return fmt.Sprintf(format, args...)
+}
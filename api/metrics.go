@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors of one server, in a registry of its
+// own rather than the global one.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	reservationsCreated   prometheus.Counter
+	reservationsCancelled prometheus.Counter
+	bookingConflicts      prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		reservationsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reservations_created_total",
+			Help: "Reservations booked.",
+		}),
+		reservationsCancelled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reservations_cancelled_total",
+			Help: "Reservations deleted.",
+		}),
+		bookingConflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "booking_conflicts_total",
+			Help: "Bookings refused because the creneau was already taken.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.reservationsCreated,
+		m.reservationsCancelled,
+		m.bookingConflicts,
+	)
+	return m
+}
+
+// watchDB exports the connection pool statistics of conn.
+func (m *metrics) watchDB(conn *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(conn, name))
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument counts and times the requests served by mux. Requests are
+// labelled with the pattern they matched rather than their path, so that ids
+// in paths or typos do not create new series; the catch-all counts as
+// unmatched.
+func (m *metrics) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" || route == "/" {
+			route = "unmatched"
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
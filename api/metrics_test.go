@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated)
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusConflict)
+		api.do("GET", "/api/nowhere/42", api.admin, nil).expect(t, http.StatusNotFound)
+
+		body := string(api.do("GET", "/metrics", "", nil).expect(t, http.StatusOK).Body)
+		for _, line := range []string{
+			`http_requests_total{method="POST",route="/api/reservations/add",status="201"} 1`,
+			`http_requests_total{method="POST",route="/api/reservations/add",status="409"} 1`,
+			`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+			"reservations_created_total 1",
+			"booking_conflicts_total 1",
+		} {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("no %s in /metrics", line)
+			}
+		}
+	})
+}
@@ -0,0 +1,853 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A migration moves the schema one version up or down. PostgreSQL and SQLite
+// run each step in a transaction with its schema_migrations row. MySQL
+// commits DDL implicitly, so its steps are written to be safe to re-run after
+// a failure half-way through.
+type migration struct {
+	version int
+	name    string
+	up      func(c migrationConn) error
+	down    func(c migrationConn) error
+}
+
+// migrationConn is what migration steps run their statements on: the
+// database, or the transaction of the step. *sql.DB and *sql.Tx implement it.
+type migrationConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type migrationStatus struct {
+	migration
+	appliedAt sql.NullTime
+}
+
+var dropTables = execAll(
+	"DROP TABLE IF EXISTS sessions",
+	"DROP TABLE IF EXISTS reservations",
+	"DROP TABLE IF EXISTS horaires",
+	"DROP TABLE IF EXISTS creneaux",
+	"DROP TABLE IF EXISTS coiffeur_services",
+	"DROP TABLE IF EXISTS services",
+	"DROP TABLE IF EXISTS coiffeurs",
+	"DROP TABLE IF EXISTS salons",
+	"DROP TABLE IF EXISTS clients",
+)
+
+// Migrations are applied in order and never edited once released; schema
+// changes go into a new migration at the end of the list of every dialect,
+// under the same version number.
+var mysqlMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline schema",
+		up:      baselineUp,
+		down:    dropTables,
+	},
+	{
+		version: 2,
+		name:    "convert creneau dates to timestamps",
+		up:      convertCreneauDates,
+		// The text dates are kept, so there is nothing to undo.
+		down: execAll(),
+	},
+	{
+		version: 3,
+		name:    "foreign keys",
+		up: func(c migrationConn) error {
+			for _, fk := range foreignKeys {
+				if err := ensureForeignKey(c, fk); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(c migrationConn) error {
+			for i := len(foreignKeys) - 1; i >= 0; i-- {
+				if err := dropForeignKey(c, foreignKeys[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "row versions",
+		up: func(c migrationConn) error {
+			for _, table := range versionedTables {
+				if err := ensureColumn(c, table, "version", "INT NOT NULL DEFAULT 1"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(c migrationConn) error {
+			for _, table := range versionedTables {
+				if err := dropColumn(c, table, "version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 5,
+		name:    "unique creneau starts",
+		up: func(c migrationConn) error {
+			if err := dedupeCreneauStarts(c); err != nil {
+				return err
+			}
+			exists, err := indexExists(c, "creneaux", creneauStartsIndex)
+			if err != nil || exists {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX " + creneauStartsIndex + " ON creneaux (id_coiffeur, start_at)")(c)
+		},
+		down: func(c migrationConn) error {
+			exists, err := indexExists(c, "creneaux", creneauStartsIndex)
+			if err != nil || !exists {
+				return err
+			}
+			return execAll("DROP INDEX " + creneauStartsIndex + " ON creneaux")(c)
+		},
+	},
+	{
+		version: 6,
+		name:    "unique client emails",
+		up: func(c migrationConn) error {
+			if err := checkClientEmails(c); err != nil {
+				return err
+			}
+			exists, err := indexExists(c, "clients", clientEmailsIndex)
+			if err != nil || exists {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX " + clientEmailsIndex + " ON clients (email)")(c)
+		},
+		down: func(c migrationConn) error {
+			exists, err := indexExists(c, "clients", clientEmailsIndex)
+			if err != nil || !exists {
+				return err
+			}
+			return execAll("DROP INDEX " + clientEmailsIndex + " ON clients")(c)
+		},
+	},
+	{
+		version: 7,
+		name:    "service and horaire versions",
+		up: func(c migrationConn) error {
+			for _, table := range []string{"services", "horaires"} {
+				if err := ensureColumn(c, table, "version", "INT NOT NULL DEFAULT 1"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(c migrationConn) error {
+			for _, table := range []string{"horaires", "services"} {
+				if err := dropColumn(c, table, "version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// creneauStartsIndex keeps a coiffeur from having two creneaux starting at
+// the same time.
+const creneauStartsIndex = "ux_creneaux_coiffeur_start"
+
+// clientEmailsIndex keeps two accounts from sharing an email, which would
+// make logins ambiguous.
+const clientEmailsIndex = "ux_clients_email"
+
+// Tables whose rows carry a version, bumped by every change and served as the
+// ETag of the resource. Services and horaires got theirs in migration 7.
+var versionedTables = []string{"clients", "salons", "coiffeurs", "creneaux", "reservations"}
+
+type foreignKey struct {
+	table, name, definition string
+}
+
+// Rows owned by a parent (sessions, schedules, service links) go away with
+// it; deleting a parent that reservations still point to is refused.
+var foreignKeys = []foreignKey{
+	{"coiffeurs", "fk_coiffeurs_salon", "FOREIGN KEY (id_salon) REFERENCES salons (id_salon)"},
+	{"services", "fk_services_salon", "FOREIGN KEY (id_salon) REFERENCES salons (id_salon)"},
+	{"coiffeur_services", "fk_coiffeur_services_coiffeur", "FOREIGN KEY (id_coiffeur) REFERENCES coiffeurs (id_coiffeur) ON DELETE CASCADE"},
+	{"coiffeur_services", "fk_coiffeur_services_service", "FOREIGN KEY (id_service) REFERENCES services (id_service) ON DELETE CASCADE"},
+	{"horaires", "fk_horaires_coiffeur", "FOREIGN KEY (id_coiffeur) REFERENCES coiffeurs (id_coiffeur) ON DELETE CASCADE"},
+	{"creneaux", "fk_creneaux_coiffeur", "FOREIGN KEY (id_coiffeur) REFERENCES coiffeurs (id_coiffeur)"},
+	{"reservations", "fk_reservations_client", "FOREIGN KEY (id_client) REFERENCES clients (id_client)"},
+	{"reservations", "fk_reservations_salon", "FOREIGN KEY (id_salon) REFERENCES salons (id_salon)"},
+	{"reservations", "fk_reservations_coiffeur", "FOREIGN KEY (id_coiffeur) REFERENCES coiffeurs (id_coiffeur)"},
+	{"reservations", "fk_reservations_creneau", "FOREIGN KEY (id_creneau) REFERENCES creneaux (id_creneau)"},
+	{"reservations", "fk_reservations_service", "FOREIGN KEY (id_service) REFERENCES services (id_service)"},
+	{"sessions", "fk_sessions_client", "FOREIGN KEY (id_client) REFERENCES clients (id_client) ON DELETE CASCADE"},
+}
+
+// PostgreSQL and SQLite databases start from the current schema, with the
+// foreign keys in place, so steps 2 and 3 have nothing left to do for them.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline schema",
+		up:      createTables("SERIAL PRIMARY KEY", "TIMESTAMP"),
+		down:    dropTables,
+	},
+	{version: 2, name: "convert creneau dates to timestamps", up: execAll(), down: execAll()},
+	{version: 3, name: "foreign keys", up: execAll(), down: execAll()},
+	{
+		version: 4,
+		name:    "row versions",
+		up: execAll(
+			"ALTER TABLE clients ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+			"ALTER TABLE salons ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+			"ALTER TABLE coiffeurs ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+			"ALTER TABLE creneaux ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+			"ALTER TABLE reservations ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+		),
+		down: execAll(
+			"ALTER TABLE reservations DROP COLUMN IF EXISTS version",
+			"ALTER TABLE creneaux DROP COLUMN IF EXISTS version",
+			"ALTER TABLE coiffeurs DROP COLUMN IF EXISTS version",
+			"ALTER TABLE salons DROP COLUMN IF EXISTS version",
+			"ALTER TABLE clients DROP COLUMN IF EXISTS version",
+		),
+	},
+	{
+		version: 5,
+		name:    "unique creneau starts",
+		up: func(c migrationConn) error {
+			if err := dedupeCreneauStarts(c); err != nil {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX IF NOT EXISTS " + creneauStartsIndex + " ON creneaux (id_coiffeur, start_at)")(c)
+		},
+		down: execAll("DROP INDEX IF EXISTS " + creneauStartsIndex),
+	},
+	{
+		version: 6,
+		name:    "unique client emails",
+		up: func(c migrationConn) error {
+			if err := checkClientEmails(c); err != nil {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX IF NOT EXISTS " + clientEmailsIndex + " ON clients (email)")(c)
+		},
+		down: execAll("DROP INDEX IF EXISTS " + clientEmailsIndex),
+	},
+	{
+		version: 7,
+		name:    "service and horaire versions",
+		up: execAll(
+			"ALTER TABLE services ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+			"ALTER TABLE horaires ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+		),
+		down: execAll(
+			"ALTER TABLE horaires DROP COLUMN IF EXISTS version",
+			"ALTER TABLE services DROP COLUMN IF EXISTS version",
+		),
+	},
+}
+
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline schema",
+		up:      createTables("INTEGER PRIMARY KEY AUTOINCREMENT", "DATETIME"),
+		down:    dropTables,
+	},
+	{version: 2, name: "convert creneau dates to timestamps", up: execAll(), down: execAll()},
+	{version: 3, name: "foreign keys", up: execAll(), down: execAll()},
+	{
+		version: 4,
+		name:    "row versions",
+		up: execAll(
+			"ALTER TABLE clients ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			"ALTER TABLE salons ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			"ALTER TABLE coiffeurs ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			"ALTER TABLE creneaux ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			"ALTER TABLE reservations ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+		),
+		down: execAll(
+			"ALTER TABLE reservations DROP COLUMN version",
+			"ALTER TABLE creneaux DROP COLUMN version",
+			"ALTER TABLE coiffeurs DROP COLUMN version",
+			"ALTER TABLE salons DROP COLUMN version",
+			"ALTER TABLE clients DROP COLUMN version",
+		),
+	},
+	{
+		version: 5,
+		name:    "unique creneau starts",
+		up: func(c migrationConn) error {
+			if err := dedupeCreneauStarts(c); err != nil {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX IF NOT EXISTS " + creneauStartsIndex + " ON creneaux (id_coiffeur, start_at)")(c)
+		},
+		down: execAll("DROP INDEX IF EXISTS " + creneauStartsIndex),
+	},
+	{
+		version: 6,
+		name:    "unique client emails",
+		up: func(c migrationConn) error {
+			if err := checkClientEmails(c); err != nil {
+				return err
+			}
+			return execAll("CREATE UNIQUE INDEX IF NOT EXISTS " + clientEmailsIndex + " ON clients (email)")(c)
+		},
+		down: execAll("DROP INDEX IF EXISTS " + clientEmailsIndex),
+	},
+	{
+		version: 7,
+		name:    "service and horaire versions",
+		up: execAll(
+			"ALTER TABLE services ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+			"ALTER TABLE horaires ADD COLUMN version INTEGER NOT NULL DEFAULT 1",
+		),
+		down: execAll(
+			"ALTER TABLE horaires DROP COLUMN version",
+			"ALTER TABLE services DROP COLUMN version",
+		),
+	},
+}
+
+func execAll(statements ...string) func(c migrationConn) error {
+	return func(c migrationConn) error {
+		for _, statement := range statements {
+			if _, err := c.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// baselineUp creates the schema as it stood before versioned migrations. On
+// databases created by earlier builds the tables already exist, so the columns
+// those builds added over time are filled in as well.
+func baselineUp(c migrationConn) error {
+	err := execAll(`
+		CREATE TABLE IF NOT EXISTS clients (
+			id_client INT AUTO_INCREMENT PRIMARY KEY,
+			firstname VARCHAR(150),
+			lastname VARCHAR(150),
+			email VARCHAR(150),
+			password VARCHAR(255),
+			role VARCHAR(20) NOT NULL DEFAULT 'client',
+			id_salon INT NULL,
+			id_coiffeur INT NULL
+		)`, `
+		CREATE TABLE IF NOT EXISTS salons (
+			id_salon INT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(150),
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'
+		)`, `
+		CREATE TABLE IF NOT EXISTS coiffeurs (
+			id_coiffeur INT AUTO_INCREMENT PRIMARY KEY,
+			id_salon INT,
+			firstname VARCHAR(150),
+			lastname VARCHAR(150)
+		)`, `
+		CREATE TABLE IF NOT EXISTS services (
+			id_service INT AUTO_INCREMENT PRIMARY KEY,
+			id_salon INT,
+			name VARCHAR(150),
+			duration_minutes INT,
+			price DECIMAL(10,2),
+			category VARCHAR(150)
+		)`, `
+		CREATE TABLE IF NOT EXISTS coiffeur_services (
+			id_coiffeur INT,
+			id_service INT,
+			PRIMARY KEY (id_coiffeur, id_service)
+		)`, `
+		CREATE TABLE IF NOT EXISTS creneaux (
+			id_creneau INT AUTO_INCREMENT PRIMARY KEY,
+			id_coiffeur INT,
+			date_creneau VARCHAR(150),
+			duration_minutes INT NOT NULL DEFAULT 30,
+			start_at DATETIME NULL,
+			end_at DATETIME NULL,
+			availability BOOLEAN
+		)`, `
+		CREATE TABLE IF NOT EXISTS horaires (
+			id_horaire INT AUTO_INCREMENT PRIMARY KEY,
+			id_coiffeur INT,
+			weekday TINYINT,
+			start_time CHAR(5),
+			end_time CHAR(5)
+		)`, `
+		CREATE TABLE IF NOT EXISTS reservations (
+			id_reservation INT AUTO_INCREMENT PRIMARY KEY,
+			id_client INT,
+			id_salon INT,
+			id_coiffeur INT,
+			id_creneau INT,
+			id_service INT,
+			duration_minutes INT,
+			price DECIMAL(10,2)
+		)`, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id_session INT AUTO_INCREMENT PRIMARY KEY,
+			id_client INT,
+			token_hash CHAR(64) UNIQUE,
+			expires_at DATETIME,
+			revoked_at DATETIME NULL
+		)`)(c)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct{ table, column, definition string }{
+		{"clients", "role", "VARCHAR(20) NOT NULL DEFAULT 'client'"},
+		{"clients", "id_salon", "INT NULL"},
+		{"clients", "id_coiffeur", "INT NULL"},
+		{"salons", "timezone", "VARCHAR(64) NOT NULL DEFAULT 'UTC'"},
+		{"creneaux", "duration_minutes", "INT NOT NULL DEFAULT 30 AFTER date_creneau"},
+		{"creneaux", "start_at", "DATETIME NULL AFTER duration_minutes"},
+		{"creneaux", "end_at", "DATETIME NULL AFTER start_at"},
+		{"reservations", "id_client", "INT AFTER id_reservation"},
+		{"reservations", "id_service", "INT"},
+		{"reservations", "duration_minutes", "INT"},
+		{"reservations", "price", "DECIMAL(10,2)"},
+	}
+	for _, col := range columns {
+		if err := ensureColumn(c, col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTables creates the schema in its current form, foreign keys
+// included, for databases that never ran the MySQL history. serial is the
+// type of generated primary keys and timestamp the type of UTC times.
+func createTables(serial, timestamp string) func(c migrationConn) error {
+	return func(c migrationConn) error {
+		return execAll(`
+			CREATE TABLE IF NOT EXISTS clients (
+				id_client `+serial+`,
+				firstname VARCHAR(150),
+				lastname VARCHAR(150),
+				email VARCHAR(150),
+				password VARCHAR(255),
+				role VARCHAR(20) NOT NULL DEFAULT 'client',
+				id_salon INT NULL,
+				id_coiffeur INT NULL
+			)`, `
+			CREATE TABLE IF NOT EXISTS salons (
+				id_salon `+serial+`,
+				name VARCHAR(150),
+				timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'
+			)`, `
+			CREATE TABLE IF NOT EXISTS coiffeurs (
+				id_coiffeur `+serial+`,
+				id_salon INT REFERENCES salons (id_salon),
+				firstname VARCHAR(150),
+				lastname VARCHAR(150)
+			)`, `
+			CREATE TABLE IF NOT EXISTS services (
+				id_service `+serial+`,
+				id_salon INT REFERENCES salons (id_salon),
+				name VARCHAR(150),
+				duration_minutes INT,
+				price DECIMAL(10,2),
+				category VARCHAR(150)
+			)`, `
+			CREATE TABLE IF NOT EXISTS coiffeur_services (
+				id_coiffeur INT REFERENCES coiffeurs (id_coiffeur) ON DELETE CASCADE,
+				id_service INT REFERENCES services (id_service) ON DELETE CASCADE,
+				PRIMARY KEY (id_coiffeur, id_service)
+			)`, `
+			CREATE TABLE IF NOT EXISTS creneaux (
+				id_creneau `+serial+`,
+				id_coiffeur INT REFERENCES coiffeurs (id_coiffeur),
+				start_at `+timestamp+` NULL,
+				end_at `+timestamp+` NULL,
+				availability BOOLEAN
+			)`, `
+			CREATE TABLE IF NOT EXISTS horaires (
+				id_horaire `+serial+`,
+				id_coiffeur INT REFERENCES coiffeurs (id_coiffeur) ON DELETE CASCADE,
+				weekday SMALLINT,
+				start_time CHAR(5),
+				end_time CHAR(5)
+			)`, `
+			CREATE TABLE IF NOT EXISTS reservations (
+				id_reservation `+serial+`,
+				id_client INT REFERENCES clients (id_client),
+				id_salon INT REFERENCES salons (id_salon),
+				id_coiffeur INT REFERENCES coiffeurs (id_coiffeur),
+				id_creneau INT REFERENCES creneaux (id_creneau),
+				id_service INT REFERENCES services (id_service),
+				duration_minutes INT,
+				price DECIMAL(10,2)
+			)`, `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id_session `+serial+`,
+				id_client INT REFERENCES clients (id_client) ON DELETE CASCADE,
+				token_hash CHAR(64) UNIQUE,
+				expires_at `+timestamp+`,
+				revoked_at `+timestamp+` NULL
+			)`)(c)
+	}
+}
+
+// ensureColumn adds a column to a table created by an earlier version of the
+// schema, since CREATE TABLE IF NOT EXISTS leaves existing tables untouched.
+func ensureColumn(c migrationConn, table, column, definition string) error {
+	var count int
+	row := c.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=?", table, column)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err := c.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func dropColumn(c migrationConn, table, column string) error {
+	var count int
+	row := c.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=?", table, column)
+	if err := row.Scan(&count); err != nil || count == 0 {
+		return err
+	}
+
+	_, err := c.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+	return err
+}
+
+func indexExists(c migrationConn, table, name string) (bool, error) {
+	var count int
+	row := c.QueryRow("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND INDEX_NAME=?", table, name)
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+// dedupeCreneauStarts deletes the creneaux that start when another one of the
+// same coiffeur does, so that creneauStartsIndex can be created. Of each
+// group the booked creneau is kept, or else the oldest. Two booked creneaux
+// starting together have to be sorted out by hand first.
+func dedupeCreneauStarts(c migrationConn) error {
+	rows, err := c.Query(`
+		SELECT c.id_creneau, c.id_coiffeur, c.start_at, COUNT(r.id_reservation)
+		FROM creneaux c
+		LEFT JOIN reservations r ON r.id_creneau = c.id_creneau
+		WHERE c.start_at IS NOT NULL
+		GROUP BY c.id_creneau, c.id_coiffeur, c.start_at
+		ORDER BY c.id_coiffeur, c.start_at, c.id_creneau`)
+	if err != nil {
+		return err
+	}
+
+	type creneauStart struct {
+		id, idCoiffeur, bookings int
+		start                    time.Time
+	}
+	var group, duplicates []creneauStart
+	var conflicts []string
+	flush := func() {
+		keep := 0
+		for i, creneau := range group {
+			if creneau.bookings > 0 {
+				keep = i
+			}
+		}
+		for i, creneau := range group {
+			switch {
+			case i == keep:
+			case creneau.bookings > 0:
+				conflicts = append(conflicts, strconv.Itoa(creneau.id))
+			default:
+				duplicates = append(duplicates, creneau)
+			}
+		}
+		group = group[:0]
+	}
+	for rows.Next() {
+		var creneau creneauStart
+		if err := rows.Scan(&creneau.id, &creneau.idCoiffeur, &creneau.start, &creneau.bookings); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(group) > 0 && (group[0].idCoiffeur != creneau.idCoiffeur || !group[0].start.Equal(creneau.start)) {
+			flush()
+		}
+		group = append(group, creneau)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flush()
+	if len(conflicts) > 0 {
+		return fmt.Errorf("booked creneaux %s start at the same time as another booked creneau of their coiffeur", strings.Join(conflicts, ", "))
+	}
+
+	for _, creneau := range duplicates {
+		if _, err := c.Exec(dbDialect.rebind("DELETE FROM creneaux WHERE id_creneau=?"), creneau.id); err != nil {
+			return err
+		}
+	}
+	if len(duplicates) > 0 {
+		slog.Info("duplicate creneaux deleted", "count", len(duplicates))
+	}
+	return nil
+}
+
+// checkClientEmails fails when accounts share an email, so that
+// clientEmailsIndex can be created. Accounts cannot be merged blindly; the
+// duplicates have to be sorted out by hand first.
+func checkClientEmails(c migrationConn) error {
+	rows, err := c.Query("SELECT email FROM clients WHERE email IS NOT NULL GROUP BY email HAVING COUNT(*) > 1 ORDER BY email")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var shared []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return err
+		}
+		shared = append(shared, strconv.Quote(email))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(shared) > 0 {
+		return fmt.Errorf("emails %s are used by more than one client", strings.Join(shared, ", "))
+	}
+	return nil
+}
+
+func foreignKeyExists(c migrationConn, fk foreignKey) (bool, error) {
+	var count int
+	row := c.QueryRow("SELECT COUNT(*) FROM information_schema.TABLE_CONSTRAINTS WHERE CONSTRAINT_SCHEMA=DATABASE() AND TABLE_NAME=? AND CONSTRAINT_NAME=? AND CONSTRAINT_TYPE='FOREIGN KEY'", fk.table, fk.name)
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+// ensureForeignKey adds a constraint unless it is already there. Rows pointing
+// to missing parents make it fail; they have to be fixed before migrating.
+func ensureForeignKey(c migrationConn, fk foreignKey) error {
+	exists, err := foreignKeyExists(c, fk)
+	if err != nil || exists {
+		return err
+	}
+	_, err = c.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", fk.table, fk.name, fk.definition))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fk.name, err)
+	}
+	return nil
+}
+
+func dropForeignKey(c migrationConn, fk foreignKey) error {
+	exists, err := foreignKeyExists(c, fk)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = c.Exec(fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", fk.table, fk.name))
+	return err
+}
+
+// MIGRATIONS
+func ensureMigrationsTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(150),
+			applied_at ` + dbDialect.timestamp + `
+		)`)
+	return err
+}
+
+func schemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+func latestVersion() int {
+	migrations := dbDialect.migrations
+	return migrations[len(migrations)-1].version
+}
+
+// migrateTo applies or reverts migrations until the schema is at target.
+func migrateTo(target int) error {
+	if target < 0 || target > latestVersion() {
+		return fmt.Errorf("unknown schema version %d (latest is %d)", target, latestVersion())
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return err
+	}
+	current, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	migrations := dbDialect.migrations
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		err := migrationStep(func(c migrationConn) error {
+			if err := m.up(c); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			_, err := c.Exec(dbDialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.version, m.name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("migrated up", "version", m.version, "name", m.name)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		err := migrationStep(func(c migrationConn) error {
+			if err := m.down(c); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			_, err := c.Exec(dbDialect.rebind("DELETE FROM schema_migrations WHERE version=?"), m.version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("migrated down", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+// migrationStep runs one step of migrateTo in a transaction where the
+// dialect's DDL is transactional, so that a failure leaves the schema and
+// schema_migrations as they were.
+func migrationStep(step func(c migrationConn) error) error {
+	if !dbDialect.transactionalDDL {
+		return step(db)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrationStatuses() ([]migrationStatus, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]sql.NullTime{}
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []migrationStatus
+	for _, m := range dbDialect.migrations {
+		statuses = append(statuses, migrationStatus{migration: m, appliedAt: applied[m.version]})
+	}
+	return statuses, nil
+}
+
+// runMigrateCommand implements
+//
+//	main migrate up          apply every pending migration
+//	main migrate down        revert the last applied migration
+//	main migrate to VERSION  migrate up or down to VERSION
+//	main migrate status      list migrations and when they were applied
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status|to VERSION")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrateTo(latestVersion())
+	case "down":
+		if err := ensureMigrationsTable(); err != nil {
+			return err
+		}
+		current, err := schemaVersion()
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			return nil
+		}
+		previous := 0
+		for _, m := range dbDialect.migrations {
+			if m.version < current {
+				previous = m.version
+			}
+		}
+		return migrateTo(previous)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("usage: migrate to VERSION")
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return migrateTo(target)
+	case "status":
+		statuses, err := migrationStatuses()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.appliedAt.Valid {
+				state = "applied " + s.appliedAt.Time.Format(time.RFC3339)
+			}
+			fmt.Printf("%3d  %-40s %s\n", s.version, s.name, state)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
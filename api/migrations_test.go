@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUniqueCreneauStartsMigration(t *testing.T) {
+	openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err := migrateTo(4); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+	err := execAll(
+		"INSERT INTO salons (id_salon, name) VALUES (1, 'Salon')",
+		"INSERT INTO coiffeurs (id_coiffeur, id_salon, firstname, lastname) VALUES (1, 1, 'A', 'B'), (2, 1, 'C', 'D')",
+	)(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1, 2 and 3 share a start and 2 is booked; 4 is another coiffeur's.
+	for id, idCoiffeur := range map[int]int{1: 1, 2: 1, 3: 1, 4: 2} {
+		_, err := db.Exec("INSERT INTO creneaux (id_creneau, id_coiffeur, start_at, end_at, availability) VALUES (?, ?, ?, ?, ?)", id, idCoiffeur, start, end, id != 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := execAll("INSERT INTO reservations (id_salon, id_coiffeur, id_creneau) VALUES (1, 1, 2)")(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateTo(5); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT id_creneau FROM creneaux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 4 {
+		t.Errorf("creneaux %v left, want the booked one and the other coiffeur's: [2 4]", ids)
+	}
+
+	_, err = db.Exec("INSERT INTO creneaux (id_coiffeur, start_at, end_at, availability) VALUES (1, ?, ?, true)", start, end)
+	if err == nil {
+		t.Error("duplicate start accepted after the migration")
+	}
+	if err := migrateTo(4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueClientEmailsMigration(t *testing.T) {
+	openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err := migrateTo(5); err != nil {
+		t.Fatal(err)
+	}
+	err := execAll("INSERT INTO clients (id_client, firstname, lastname, email) VALUES (1, 'A', 'B', 'a@example.com'), (2, 'C', 'D', 'a@example.com')")(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrateTo(6)
+	if err == nil || !strings.Contains(err.Error(), `"a@example.com"`) {
+		t.Fatalf("migrating with a shared email: %v, want an error naming it", err)
+	}
+	if err := execAll("UPDATE clients SET email='c@example.com' WHERE id_client=2")(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTo(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := execAll("INSERT INTO clients (firstname, lastname, email) VALUES ('E', 'F', 'a@example.com')")(db); err == nil {
+		t.Error("shared email accepted after the migration")
+	}
+
+	if err := migrateTo(5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedMigrationStepRollsBack(t *testing.T) {
+	openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err := migrateTo(6); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 7 adds the services column, then fails on the horaires one.
+	if err := execAll("ALTER TABLE horaires ADD COLUMN version INTEGER")(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTo(7); err == nil {
+		t.Fatal("migrated over an existing column")
+	}
+	if version, err := schemaVersion(); err != nil || version != 6 {
+		t.Fatalf("schema version %d (%v), want 6", version, err)
+	}
+	if _, err := db.Exec("SELECT version FROM services"); err == nil {
+		t.Error("services.version kept after the step failed")
+	}
+
+	if err := execAll("ALTER TABLE horaires DROP COLUMN version")(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTo(7); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var errPatchNotObject = errors.New("a merge patch must be a JSON object")
+
+// decodeUpdate reads the body of an update into v. A PUT body is the whole
+// resource. A PATCH body is a JSON merge patch (RFC 7396) applied to the
+// current resource, which get loads by the idName parameter of the query
+// string or body; members it leaves out keep their value and null members are
+// cleared. It answers r itself and returns false on failure.
+func decodeUpdate[T any](w http.ResponseWriter, r *http.Request, v *T, idName string, get func(ctx context.Context, id int) (T, error)) bool {
+	if r.Method != http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			invalidBody(w, r, err)
+			return false
+		}
+		return true
+	}
+
+	id := requestInt(r, idName)
+	if id == 0 {
+		invalidParam(w, r, idName)
+		return false
+	}
+	current, err := get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return false
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		invalidBody(w, r, err)
+		return false
+	}
+	merged, err := mergeJSON(current, patch)
+	if err != nil {
+		invalidBody(w, r, err)
+		return false
+	}
+	// The id in the path or query string names the resource; a patch cannot
+	// move the update to another one.
+	merged[idName] = json.Number(strconv.Itoa(id))
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		invalidBody(w, r, err)
+		return false
+	}
+	return true
+}
+
+// mergeJSON applies a merge patch to the JSON form of current.
+func mergeJSON(current any, patch []byte) (map[string]any, error) {
+	var target map[string]any
+	body, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeNumbers(body, &target); err != nil {
+		return nil, err
+	}
+
+	var changes any
+	if err := decodeNumbers(patch, &changes); err != nil {
+		return nil, err
+	}
+	object, ok := changes.(map[string]any)
+	if !ok {
+		return nil, errPatchNotObject
+	}
+	return mergePatch(target, object), nil
+}
+
+// mergePatch is the MergePatch algorithm of RFC 7396 for an object patch.
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for name, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, name)
+		case map[string]any:
+			inner, _ := target[name].(map[string]any)
+			target[name] = mergePatch(inner, value)
+		default:
+			target[name] = value
+		}
+	}
+	return target
+}
+
+// decodeNumbers decodes JSON keeping numbers as written, so that ids and
+// prices survive the round trip exactly.
+func decodeNumbers(body []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
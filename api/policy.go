@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ROLES
+const (
+	RoleClient   = "client"
+	RoleCoiffeur = "coiffeur"
+	RoleManager  = "manager"
+	RoleAdmin    = "admin"
+)
+
+func validRole(role string) bool {
+	switch role {
+	case RoleClient, RoleCoiffeur, RoleManager, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// A policy decides whether the authenticated principal may perform the
+// request. Policies read the ids they need from the query string or the JSON
+// body and look up ownership in the stores; they never write.
+type policy func(r *http.Request, p Principal) (bool, error)
+
+// authorize authenticates the request, then lets admins through and checks
+// everyone else against allow. Denials are answered with 403.
+func (s *server) authorize(allow policy, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if name := conflictingID(r); name != "" {
+			badRequest(w, r, "conflicting_id", name+" has different values in the query string and the body")
+			return
+		}
+
+		principal, _ := sessionPrincipal(r.Context())
+		if principal.Role != RoleAdmin {
+			ok, err := allow(r, principal)
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			if !ok {
+				writeProblem(w, r, http.StatusForbidden, "forbidden", "you are not allowed to do this")
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+// requestInt reads an integer parameter from the query string, falling back to
+// the JSON body. Missing or malformed values read as 0, which no policy
+// accepts.
+func requestInt(r *http.Request, name string) int {
+	if value := r.URL.Query().Get(name); value != "" {
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(requestBody(r), &fields) != nil {
+		return 0
+	}
+	var n int
+	json.Unmarshal(fields[name], &n)
+	return n
+}
+
+// requestBody reads the body of r and restores it so the handler can decode
+// it again.
+func requestBody(r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// conflictingID returns the name of an id given both in the query string and
+// in the JSON body with different values. Policies read the query string
+// first while handlers decode the body, so such a request would be checked
+// against one row and applied to another.
+func conflictingID(r *http.Request) string {
+	var fields map[string]json.RawMessage
+	for name, values := range r.URL.Query() {
+		if !strings.HasPrefix(name, "id_") {
+			continue
+		}
+		if fields == nil && json.Unmarshal(requestBody(r), &fields) != nil {
+			return ""
+		}
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		queryID, err := strconv.Atoi(values[0])
+		var bodyID int
+		if err != nil || json.Unmarshal(raw, &bodyID) != nil || bodyID != queryID {
+			return name
+		}
+	}
+	return ""
+}
+
+// unchanged reports whether the request is a PATCH leaving the named id as
+// it is, in which case the checks on the current resource are enough.
+func unchanged(r *http.Request, name string) bool {
+	return r.Method == http.MethodPatch && requestInt(r, name) == 0
+}
+
+// coiffeurSalon returns the salon of a coiffeur; a missing coiffeur reads as
+// 0, which no manager matches.
+func (s *server) coiffeurSalon(ctx context.Context, idCoiffeur int) (int, error) {
+	coiffeur, err := s.coiffeurs.GetCoiffeur(ctx, idCoiffeur)
+	if err == errNotFound {
+		return 0, nil
+	}
+	return coiffeur.ID_salon, err
+}
+
+func managesSalon(p Principal, idSalon int) bool {
+	return p.Role == RoleManager && p.ID_salon != 0 && p.ID_salon == idSalon
+}
+
+func (s *server) managesCoiffeur(ctx context.Context, p Principal, idCoiffeur int) (bool, error) {
+	if p.Role == RoleCoiffeur {
+		return p.ID_coiffeur != 0 && p.ID_coiffeur == idCoiffeur, nil
+	}
+	idSalon, err := s.coiffeurSalon(ctx, idCoiffeur)
+	if err != nil {
+		return false, err
+	}
+	return managesSalon(p, idSalon), nil
+}
+
+// POLICIES
+func anyone(r *http.Request, p Principal) (bool, error) {
+	return true, nil
+}
+
+func adminOnly(r *http.Request, p Principal) (bool, error) {
+	return false, nil
+}
+
+// self allows clients to act on their own account and reservations.
+func self(r *http.Request, p Principal) (bool, error) {
+	idClient := requestInt(r, "id_client")
+	return idClient != 0 && idClient == p.ID_client, nil
+}
+
+func canManageSalon(r *http.Request, p Principal) (bool, error) {
+	return managesSalon(p, requestInt(r, "id_salon")), nil
+}
+
+// canManageCoiffeur allows a manager to edit the coiffeurs of their salon. On
+// update the coiffeur must stay in that salon.
+func (s *server) canManageCoiffeur(r *http.Request, p Principal) (bool, error) {
+	if p.Role != RoleManager {
+		return false, nil
+	}
+
+	if idCoiffeur := requestInt(r, "id_coiffeur"); idCoiffeur != 0 {
+		idSalon, err := s.coiffeurSalon(r.Context(), idCoiffeur)
+		if err != nil || !managesSalon(p, idSalon) {
+			return false, err
+		}
+		if r.Method == http.MethodDelete || unchanged(r, "id_salon") {
+			return true, nil
+		}
+	}
+	return managesSalon(p, requestInt(r, "id_salon")), nil
+}
+
+// canManageService allows a manager to edit the catalogue of their salon.
+func (s *server) canManageService(r *http.Request, p Principal) (bool, error) {
+	if idService := requestInt(r, "id_service"); idService != 0 {
+		service, err := s.services.GetService(r.Context(), idService)
+		if err == errNotFound {
+			return false, nil
+		}
+		if err != nil || !managesSalon(p, service.ID_salon) {
+			return false, err
+		}
+		if r.Method == http.MethodDelete {
+			return true, nil
+		}
+	}
+	return managesSalon(p, requestInt(r, "id_salon")), nil
+}
+
+// canManageCreneau allows coiffeurs to edit their own creneaux and managers
+// those of their salon's coiffeurs.
+func (s *server) canManageCreneau(r *http.Request, p Principal) (bool, error) {
+	if idCreneau := requestInt(r, "id_creneau"); idCreneau != 0 {
+		creneau, err := s.creneaux.GetCreneau(r.Context(), idCreneau)
+		if err == errNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		ok, err := s.managesCoiffeur(r.Context(), p, creneau.ID_coiffeur)
+		if err != nil || !ok {
+			return false, err
+		}
+		if r.Method == http.MethodDelete || unchanged(r, "id_coiffeur") {
+			return true, nil
+		}
+	}
+	return s.managesCoiffeur(r.Context(), p, requestInt(r, "id_coiffeur"))
+}
+
+// canManageHoraire applies the creneau rules to working hours: coiffeurs edit
+// their own, managers those of their salon's coiffeurs.
+func (s *server) canManageHoraire(r *http.Request, p Principal) (bool, error) {
+	if idHoraire := requestInt(r, "id_horaire"); idHoraire != 0 {
+		horaire, err := s.horaires.GetHoraire(r.Context(), idHoraire)
+		if err == errNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		ok, err := s.managesCoiffeur(r.Context(), p, horaire.ID_coiffeur)
+		if err != nil || !ok {
+			return false, err
+		}
+		if r.Method == http.MethodDelete {
+			return true, nil
+		}
+	}
+	return s.managesCoiffeur(r.Context(), p, requestInt(r, "id_coiffeur"))
+}
+
+// canBook allows clients to book for themselves and managers to book in their
+// salon on a client's behalf.
+func canBook(r *http.Request, p Principal) (bool, error) {
+	if ok, _ := self(r, p); ok {
+		return true, nil
+	}
+	return managesSalon(p, requestInt(r, "id_salon")), nil
+}
+
+// canViewReservation allows the client who booked, the coiffeur booked and
+// the managers of the salon to read a reservation.
+func (s *server) canViewReservation(r *http.Request, p Principal) (bool, error) {
+	reservation, err := s.reservations.GetReservation(r.Context(), requestInt(r, "id_reservation"))
+	if err != nil {
+		if err == errNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch {
+	case p.ID_client != 0 && p.ID_client == reservation.ID_client:
+		return true, nil
+	case p.Role == RoleCoiffeur:
+		return p.ID_coiffeur != 0 && p.ID_coiffeur == reservation.ID_coiffeur, nil
+	}
+	return managesSalon(p, reservation.ID_salon), nil
+}
+
+// canManageReservation allows managers to edit the reservations of their
+// salon and, for cancellations, the client who booked.
+func (s *server) canManageReservation(r *http.Request, p Principal) (bool, error) {
+	reservation, err := s.reservations.GetReservation(r.Context(), requestInt(r, "id_reservation"))
+	if err != nil {
+		if err == errNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if r.Method == http.MethodDelete && reservation.ID_client != 0 && p.ID_client == reservation.ID_client {
+		return true, nil
+	}
+	if !managesSalon(p, reservation.ID_salon) {
+		return false, nil
+	}
+	if r.Method == http.MethodDelete || unchanged(r, "id_salon") {
+		return true, nil
+	}
+	return managesSalon(p, requestInt(r, "id_salon")), nil
+}
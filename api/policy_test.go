@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Policies read ids from the query string first and handlers from the body,
+// so a request giving both must not get past the policy with one and act
+// on the other.
+func TestConflictingIDsAreRejected(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		other := api.bookable(30)
+		manager := api.account(RoleManager, b.salon.ID_salon, 0)
+		attacker := api.account(RoleClient, 0, 0)
+		victim := api.account(RoleClient, 0, 0)
+
+		mine := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		theirs := api.creneau(other.coiffeur.ID_coiffeur, testStart(), 30)
+		free := api.creneau(b.coiffeur.ID_coiffeur, testStart().Add(time.Hour), 30)
+		otherFree := api.creneau(other.coiffeur.ID_coiffeur, testStart().Add(time.Hour), 30)
+		var myReservation, theirReservation Reservation
+		api.create("/api/reservations/add", b.reservation(attacker.ID_client, mine.ID_creneau), &myReservation)
+		api.create("/api/reservations/add", other.reservation(victim.ID_client, theirs.ID_creneau), &theirReservation)
+
+		tests := []struct {
+			name   string
+			method string
+			url    string
+			token  string
+			body   string
+		}{
+			{"client update", "PUT", pathf("/api/clients/update?id_client=%s", attacker.ID_client), attacker.token,
+				fmt.Sprintf(`{"id_client":%d,"firstname":"X","lastname":"Y","email":"taken@example.com","password":"new-password"}`, victim.ID_client)},
+			{"salon update", "PUT", pathf("/api/salons/update?id_salon=%s", b.salon.ID_salon), manager.token,
+				fmt.Sprintf(`{"id_salon":%d,"name":"Taken"}`, other.salon.ID_salon)},
+			{"coiffeur update", "PUT", pathf("/api/coiffeur/update?id_coiffeur=%s", b.coiffeur.ID_coiffeur), manager.token,
+				fmt.Sprintf(`{"id_coiffeur":%d,"id_salon":%d,"firstname":"X","lastname":"Y"}`, other.coiffeur.ID_coiffeur, b.salon.ID_salon)},
+			{"coiffeur moved to another salon", "PUT", pathf("/api/coiffeur/update?id_coiffeur=%s&id_salon=%s", b.coiffeur.ID_coiffeur, b.salon.ID_salon), manager.token,
+				fmt.Sprintf(`{"id_coiffeur":%d,"id_salon":%d,"firstname":"X","lastname":"Y"}`, b.coiffeur.ID_coiffeur, other.salon.ID_salon)},
+			{"reservation update", "PUT", pathf("/api/reservations/update?id_reservation=%s", myReservation.ID_reservation), manager.token,
+				fmt.Sprintf(`{"id_reservation":%d,"id_client":%d,"id_salon":%d,"id_coiffeur":%d,"id_creneau":%d,"id_service":%d}`,
+					theirReservation.ID_reservation, victim.ID_client, other.salon.ID_salon, other.coiffeur.ID_coiffeur, theirs.ID_creneau, other.service.ID_service)},
+			{"booking for another client", "POST", pathf("/api/reservations/add?id_client=%s", attacker.ID_client), attacker.token,
+				fmt.Sprintf(`{"id_client":%d,"id_salon":%d,"id_coiffeur":%d,"id_creneau":%d,"id_service":%d}`,
+					victim.ID_client, b.salon.ID_salon, b.coiffeur.ID_coiffeur, free.ID_creneau, b.service.ID_service)},
+			{"booking in another salon", "POST", pathf("/api/reservations/add?id_salon=%s", b.salon.ID_salon), manager.token,
+				fmt.Sprintf(`{"id_client":%d,"id_salon":%d,"id_coiffeur":%d,"id_creneau":%d,"id_service":%d}`,
+					victim.ID_client, other.salon.ID_salon, other.coiffeur.ID_coiffeur, otherFree.ID_creneau, other.service.ID_service)},
+		}
+		for _, test := range tests {
+			r := api.do(test.method, test.url, test.token, test.body, "If-Match", "*")
+			if r.Code != http.StatusBadRequest || r.problem(t) != "conflicting_id" {
+				t.Errorf("%s: status %d, want 400 conflicting_id: %s", test.name, r.Code, r.Body)
+			}
+		}
+
+		ctx := context.Background()
+		if client, _ := api.store.GetClient(ctx, victim.ID_client); client.Email != victim.Email {
+			t.Errorf("victim's email changed to %s", client.Email)
+		}
+		api.do("POST", "/api/auth/login", "", Credentials{Email: victim.Email, Password: testPassword}).expect(t, http.StatusOK)
+		if salon, _ := api.store.GetSalon(ctx, other.salon.ID_salon); salon.Name != other.salon.Name {
+			t.Errorf("other salon renamed to %s", salon.Name)
+		}
+		if coiffeur, _ := api.store.GetCoiffeur(ctx, other.coiffeur.ID_coiffeur); coiffeur.Firstname != other.coiffeur.Firstname {
+			t.Errorf("other coiffeur renamed to %s", coiffeur.Firstname)
+		}
+		if reservation, _ := api.store.GetReservation(ctx, theirReservation.ID_reservation); reservation != theirReservation {
+			t.Errorf("other reservation changed to %+v", reservation)
+		}
+		page, _ := api.store.ListReservations(ctx, ReservationFilter{}, ListOptions{Limit: 10, Sort: "id"})
+		if len(page.Items) != 2 {
+			t.Errorf("%d reservations, want the 2 booked by the admin", len(page.Items))
+		}
+
+		// The same id in both places is fine.
+		api.do("PUT", pathf("/api/clients/update?id_client=%s", attacker.ID_client), attacker.token,
+			fmt.Sprintf(`{"id_client":%d,"firstname":"X","lastname":"Y","email":"%s"}`, attacker.ID_client, attacker.Email), "If-Match", "*").
+			expect(t, http.StatusOK)
+	})
+}
+
+func TestClientsOnlyManageThemselves(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		client := api.account(RoleClient, 0, 0)
+		other := api.account(RoleClient, 0, 0)
+
+		api.do("GET", pathf("/api/v2/clients/%s", client.ID_client), client.token, nil).expect(t, http.StatusOK)
+		api.do("GET", pathf("/api/v2/clients/%s", other.ID_client), client.token, nil).expect(t, http.StatusForbidden)
+		api.do("PATCH", pathf("/api/v2/clients/%s", other.ID_client), client.token, `{"firstname":"X"}`, "If-Match", "*").
+			expect(t, http.StatusForbidden)
+		api.do("DELETE", pathf("/api/clients/delete?id_client=%s", other.ID_client), client.token, nil, "If-Match", "*").
+			expect(t, http.StatusForbidden)
+		api.do("GET", "/api/v2/clients", client.token, nil).expect(t, http.StatusForbidden)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Problem is an RFC 7807 problem details body. Code is a stable,
+// machine-readable identifier such as not_found; Detail is meant for people.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError tells what is wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeProblem answers r with an application/problem+json body.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, fieldErrors ...FieldError) {
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Code:     code,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   fieldErrors,
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		problem.RequestID = info.id
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method "+r.Method+" is not allowed here")
+}
+
+func resourceNotFound(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, http.StatusNotFound, "not_found", detail)
+}
+
+func badRequest(w http.ResponseWriter, r *http.Request, code, detail string) {
+	writeProblem(w, r, http.StatusBadRequest, code, detail)
+}
+
+// invalidParam reports a missing or malformed query parameter.
+func invalidParam(w http.ResponseWriter, r *http.Request, name string) {
+	writeProblem(w, r, http.StatusBadRequest, "invalid_parameter", "query parameter "+name+" is missing or invalid",
+		FieldError{Field: name, Code: "invalid", Message: "must be a valid value"})
+}
+
+// invalidBody reports a request body that is not the JSON expected.
+func invalidBody(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, "body_too_large", "the request body is too large")
+		return
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		invalidFields(w, r, FieldError{Field: typeErr.Field, Code: "invalid_type", Message: "must be a " + typeErr.Type.String()})
+		return
+	}
+	var timeErr *time.ParseError
+	if errors.As(err, &timeErr) {
+		badRequest(w, r, "invalid_json", "dates must be RFC 3339 timestamps such as 2024-05-01T09:00:00+02:00")
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, "invalid_json", "the request body is not valid JSON: "+err.Error())
+}
+
+// invalidFields reports validation failures of a request body.
+func invalidFields(w http.ResponseWriter, r *http.Request, fieldErrors ...FieldError) {
+	writeProblem(w, r, http.StatusBadRequest, "validation_failed", "the request has invalid fields", fieldErrors...)
+}
+
+// internalError logs err and answers without revealing it.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "request failed", "err", err)
+	writeProblem(w, r, http.StatusInternalServerError, "internal_error", "an unexpected error occurred")
+}
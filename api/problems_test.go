@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProblemDetails(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		for _, test := range []struct {
+			method, path, token string
+			body                any
+			status              int
+			code                string
+		}{
+			{"GET", "/api/salons", "", nil, http.StatusUnauthorized, "unauthenticated"},
+			{"GET", "/api/nowhere", api.admin, nil, http.StatusNotFound, "not_found"},
+			{"GET", "/api/salons/add", api.admin, nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+			{"POST", "/api/salons/add", api.admin, "{", http.StatusBadRequest, "invalid_json"},
+		} {
+			r := api.do(test.method, test.path, test.token, test.body, "X-Request-ID", "req-42").expect(t, test.status)
+			if ct := r.Header.Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("%s %s: content type %q", test.method, test.path, ct)
+			}
+			var problem Problem
+			r.decode(t, &problem)
+			if problem.Status != test.status || problem.Code != test.code || problem.Title != http.StatusText(test.status) ||
+				problem.Type != "about:blank" || problem.Instance != test.path || problem.RequestID != "req-42" {
+				t.Errorf("%s %s: problem %+v", test.method, test.path, problem)
+			}
+		}
+	})
+}
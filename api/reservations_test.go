@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentBookingsOneWins(t *testing.T) {
+	const attempts = 20
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		clients := make([]testAccount, attempts)
+		for i := range clients {
+			clients[i] = api.account(RoleClient, 0, 0)
+		}
+
+		codes := make([]int, attempts)
+		errs := make([]error, attempts)
+		var start, done sync.WaitGroup
+		start.Add(1)
+		for i, client := range clients {
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				start.Wait()
+				r, err := api.send("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau))
+				codes[i], errs[i] = r.Code, err
+			}()
+		}
+		start.Done()
+		done.Wait()
+
+		created, conflicts := 0, 0
+		for i, code := range codes {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			switch code {
+			case http.StatusCreated:
+				created++
+			case http.StatusConflict:
+				conflicts++
+			default:
+				t.Errorf("unexpected status %d", code)
+			}
+		}
+		if created != 1 || conflicts != attempts-1 {
+			t.Errorf("%d bookings created and %d refused, want 1 and %d", created, conflicts, attempts-1)
+		}
+
+		page, err := api.store.ListReservations(context.Background(), ReservationFilter{}, ListOptions{Limit: attempts, Sort: "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 {
+			t.Errorf("%d reservations stored, want 1", len(page.Items))
+		}
+		if taken, _ := api.store.GetCreneau(context.Background(), creneau.ID_creneau); taken.Availability {
+			t.Error("booked creneau still available")
+		}
+	})
+}
+
+func TestBookingTakesTheServiceDuration(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(60)
+		start := testStart()
+		creneaux := []Creneau{
+			api.creneau(b.coiffeur.ID_coiffeur, start, 30),
+			api.creneau(b.coiffeur.ID_coiffeur, start.Add(30*time.Minute), 30),
+			api.creneau(b.coiffeur.ID_coiffeur, start.Add(time.Hour), 30),
+		}
+		client := api.account(RoleClient, 0, 0)
+
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneaux[0].ID_creneau)).
+			expect(t, http.StatusCreated)
+		for i, want := range []bool{false, false, true} {
+			if creneau, _ := api.store.GetCreneau(context.Background(), creneaux[i].ID_creneau); creneau.Availability != want {
+				t.Errorf("creneau %d available: %t, want %t", i, creneau.Availability, want)
+			}
+		}
+
+		tests := []struct {
+			name      string
+			idCreneau int
+			code      int
+			problem   string
+		}{
+			{"overlapping the booking", creneaux[1].ID_creneau, http.StatusConflict, "creneau_unavailable"},
+			{"running past the last creneau", creneaux[2].ID_creneau, http.StatusConflict, "creneau_too_short"},
+		}
+		for _, test := range tests {
+			r := api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, test.idCreneau))
+			if r.Code != test.code || r.problem(t) != test.problem {
+				t.Errorf("%s: status %d, want %d %s: %s", test.name, r.Code, test.code, test.problem, r.Body)
+			}
+		}
+	})
+}
+
+func TestBookingChecksTheCreneauCoiffeur(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		colleague := api.coiffeur(b.salon.ID_salon, b.service.ID_service)
+		creneau := api.creneau(colleague.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+
+		r := api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusBadRequest)
+		if code := r.problem(t); code != "creneau_other_coiffeur" {
+			t.Errorf("code %q, want creneau_other_coiffeur", code)
+		}
+		if creneau, _ := api.store.GetCreneau(context.Background(), creneau.ID_creneau); !creneau.Availability {
+			t.Error("creneau of another coiffeur taken")
+		}
+	})
+}
+
+func TestCancellingFreesTheCreneaux(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(60)
+		first := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		second := api.creneau(b.coiffeur.ID_coiffeur, testStart().Add(30*time.Minute), 30)
+		client := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, first.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+		api.do("DELETE", pathf("/api/reservations/delete?id_reservation=%s", reservation.ID_reservation), client.token, nil, "If-Match", etag(reservation.Version)).
+			expect(t, http.StatusOK)
+
+		for _, creneau := range []Creneau{first, second} {
+			if creneau, _ := api.store.GetCreneau(context.Background(), creneau.ID_creneau); !creneau.Availability {
+				t.Errorf("creneau %d still taken after the cancellation", creneau.ID_creneau)
+			}
+		}
+		other := api.account(RoleClient, 0, 0)
+		api.do("POST", "/api/reservations/add", other.token, b.reservation(other.ID_client, first.ID_creneau)).
+			expect(t, http.StatusCreated)
+	})
+}
+
+func TestBookedCreneauxCannotChange(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(60)
+		first := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		second := api.creneau(b.coiffeur.ID_coiffeur, testStart().Add(30*time.Minute), 30)
+		third := api.creneau(b.coiffeur.ID_coiffeur, testStart().Add(time.Hour), 30)
+		client := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, first.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+
+		for _, creneau := range []Creneau{first, second} {
+			url := pathf("/api/v2/creneaux/%s", creneau.ID_creneau)
+			api.do("PATCH", url, api.admin, `{"availability":true}`, "If-Match", "*").expect(t, http.StatusConflict)
+			api.do("DELETE", url, api.admin, nil, "If-Match", "*").expect(t, http.StatusConflict)
+		}
+		api.do("DELETE", pathf("/api/v2/creneaux/%s", third.ID_creneau), api.admin, nil, "If-Match", "*").expect(t, http.StatusOK)
+
+		api.do("DELETE", pathf("/api/v2/reservations/%s", reservation.ID_reservation), client.token, nil, "If-Match", "*").
+			expect(t, http.StatusOK)
+		api.do("DELETE", pathf("/api/v2/creneaux/%s", second.ID_creneau), api.admin, nil, "If-Match", "*").expect(t, http.StatusOK)
+	})
+}
+
+func TestMovingAReservation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(60)
+		colleague := api.coiffeur(b.salon.ID_salon, b.service.ID_service)
+		start := testStart()
+		var mine, theirs []Creneau
+		for i := range 3 {
+			offset := time.Duration(i) * 30 * time.Minute
+			mine = append(mine, api.creneau(b.coiffeur.ID_coiffeur, start.Add(offset), 30))
+			theirs = append(theirs, api.creneau(colleague.ID_coiffeur, start.Add(offset), 30))
+		}
+		client := api.account(RoleClient, 0, 0)
+		other := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, mine[0].ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+		api.do("POST", "/api/reservations/add", other.token, Reservation{ID_client: other.ID_client, ID_salon: b.salon.ID_salon, ID_coiffeur: colleague.ID_coiffeur, ID_creneau: theirs[1].ID_creneau, ID_service: b.service.ID_service}).
+			expect(t, http.StatusCreated)
+
+		url := pathf("/api/reservations/update?id_reservation=%s", reservation.ID_reservation)
+		move := func(idCoiffeur, idCreneau int) testResponse {
+			return api.do("PATCH", url, api.admin, fmt.Sprintf(`{"id_coiffeur":%d,"id_creneau":%d}`, idCoiffeur, idCreneau), "If-Match", "*")
+		}
+		available := func(want ...bool) {
+			t.Helper()
+			for i, creneau := range mine {
+				if creneau, _ := api.store.GetCreneau(context.Background(), creneau.ID_creneau); creneau.Availability != want[i] {
+					t.Errorf("creneau %d available: %t, want %t", i, creneau.Availability, want[i])
+				}
+			}
+		}
+
+		// Half an hour later, onto a creneau the reservation already holds.
+		move(b.coiffeur.ID_coiffeur, mine[1].ID_creneau).expect(t, http.StatusOK)
+		available(true, false, false)
+
+		tests := []struct {
+			name       string
+			idCoiffeur int
+			idCreneau  int
+			code       int
+			problem    string
+		}{
+			{"onto a booked creneau", colleague.ID_coiffeur, theirs[0].ID_creneau, http.StatusConflict, "creneau_unavailable"},
+			{"onto another coiffeur's creneau", b.coiffeur.ID_coiffeur, theirs[2].ID_creneau, http.StatusBadRequest, "creneau_other_coiffeur"},
+			{"past the last creneau", b.coiffeur.ID_coiffeur, mine[2].ID_creneau, http.StatusConflict, "creneau_too_short"},
+		}
+		for _, test := range tests {
+			r := move(test.idCoiffeur, test.idCreneau)
+			if r.Code != test.code || r.problem(t) != test.problem {
+				t.Errorf("%s: status %d, want %d %s: %s", test.name, r.Code, test.code, test.problem, r.Body)
+			}
+		}
+		available(true, false, false)
+
+		api.do("POST", "/api/reservations/add", other.token, b.reservation(other.ID_client, mine[0].ID_creneau)).
+			expect(t, http.StatusConflict)
+		if moved, _ := api.store.GetReservation(context.Background(), reservation.ID_reservation); moved.ID_creneau != mine[1].ID_creneau {
+			t.Errorf("reservation on creneau %d, want %d", moved.ID_creneau, mine[1].ID_creneau)
+		}
+	})
+}
+
+func TestUpdatingAReservationKeepsItsServiceAndSalon(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		longer := api.service(b.salon.ID_salon, 60)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		elsewhere := api.salon()
+		client := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+
+		url := pathf("/api/reservations/update?id_reservation=%s", reservation.ID_reservation)
+		tests := []struct {
+			name    string
+			patch   string
+			code    int
+			problem string
+		}{
+			{"another service", fmt.Sprintf(`{"id_service":%d}`, longer.ID_service), http.StatusConflict, "service_changed"},
+			{"another salon", fmt.Sprintf(`{"id_salon":%d}`, elsewhere.ID_salon), http.StatusBadRequest, "coiffeur_other_salon"},
+		}
+		for _, test := range tests {
+			r := api.do("PATCH", url, api.admin, test.patch, "If-Match", "*")
+			if r.Code != test.code || r.problem(t) != test.problem {
+				t.Errorf("%s: status %d, want %d %s: %s", test.name, r.Code, test.code, test.problem, r.Body)
+			}
+		}
+
+		if stored, _ := api.store.GetReservation(context.Background(), reservation.ID_reservation); stored != reservation {
+			t.Errorf("stored %+v, want %+v", stored, reservation)
+		}
+	})
+}
+
+func TestReservationUpdateReturnsTheStoredRow(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+
+		var reservation Reservation
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated).decode(t, &reservation)
+		url := pathf("/api/v2/reservations/%s", reservation.ID_reservation)
+
+		// The body claims another duration and price than the service's.
+		body := fmt.Sprintf(`{"id_client":%d,"id_salon":%d,"id_coiffeur":%d,"id_creneau":%d,"id_service":%d,"duration_minutes":5,"price":1}`,
+			client.ID_client, b.salon.ID_salon, b.coiffeur.ID_coiffeur, creneau.ID_creneau, b.service.ID_service)
+		for _, method := range []string{"PUT", "PATCH"} {
+			var updated, stored Reservation
+			api.do(method, url, api.admin, body, "If-Match", "*").expect(t, http.StatusOK).decode(t, &updated)
+			r := api.do("GET", url, api.admin, nil).expect(t, http.StatusOK)
+			r.decode(t, &stored)
+
+			if updated != stored || updated.Duration != 30 || updated.Price != 25 {
+				t.Errorf("%s: response %+v, stored %+v", method, updated, stored)
+			}
+			if tag := r.Header.Get("ETag"); tag != etag(updated.Version) {
+				t.Errorf("%s: ETag %s, response version %d", method, tag, updated.Version)
+			}
+		}
+	})
+}
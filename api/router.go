@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// methods dispatches a route on the request method, answering 405 with an
+// Allow header for the others.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(m))
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	methodNotAllowed(w, r)
+}
+
+// pathID hands the {id} path segment to a v1 handler as the name parameter:
+// it is set in the query string, where policies and delete handlers look
+// first, and in the JSON object body, if any, so that it overrides any id
+// the client sent. Ids that are not positive integers match no resource.
+func pathID(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil || id <= 0 {
+			resourceNotFound(w, r, "no resource at "+r.URL.Path)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		query := r.URL.Query()
+		query.Set(name, strconv.Itoa(id))
+		r.URL.RawQuery = query.Encode()
+
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				invalidBody(w, r, err)
+				return
+			}
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(body, &fields) == nil && fields != nil {
+				fields[name] = json.RawMessage(strconv.Itoa(id))
+				body, _ = json.Marshal(fields)
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+		next(w, r)
+	}
+}
+
+// routesV2 registers the resource-oriented API under /api/v2. Every route is
+// served by the v1 handler and policy for the same operation, which keep
+// working at their old paths. Writes to versioned resources must send
+// If-Match, here as in v1.
+func (s *server) routesV2(mux *http.ServeMux) {
+	/// Auth
+	mux.Handle("/api/v2/auth/login", methods{http.MethodPost: s.loginHandler})
+	mux.Handle("/api/v2/auth/logout", methods{http.MethodPost: s.requireAuth(s.logoutHandler)})
+	mux.Handle("/api/v2/auth/refresh", methods{http.MethodPost: s.requireAuth(s.refreshHandler)})
+
+	/// Clients
+	addClient := s.authorize(adminOnly, s.addClientHandler)
+	if s.config.Features["signup"] {
+		addClient = s.addClientHandler
+	}
+	mux.Handle("/api/v2/clients", methods{
+		http.MethodGet:  s.authorize(adminOnly, s.getClientsHandler),
+		http.MethodPost: addClient,
+	})
+	mux.Handle("/api/v2/clients/{id}", methods{
+		http.MethodGet:    pathID("id_client", s.authorize(self, s.getClientHandler)),
+		http.MethodPut:    pathID("id_client", requireIfMatch(s.authorize(self, s.updateClientHandler))),
+		http.MethodPatch:  pathID("id_client", requireIfMatch(s.authorize(self, s.updateClientHandler))),
+		http.MethodDelete: pathID("id_client", requireIfMatch(s.authorize(self, s.deleteClientHandler))),
+	})
+	mux.Handle("/api/v2/clients/{id}/role", methods{
+		http.MethodPut: pathID("id_client", s.authorize(adminOnly, s.updateClientRoleHandler)),
+	})
+	mux.Handle("/api/v2/clients/{id}/reservations", methods{
+		http.MethodGet: pathID("id_client", s.authorize(self, s.getReservationsHandler)),
+	})
+
+	/// Salons
+	mux.Handle("/api/v2/salons", methods{
+		http.MethodGet:  s.authorize(anyone, s.getSalonsHandler),
+		http.MethodPost: s.authorize(adminOnly, s.addSalonHandler),
+	})
+	mux.Handle("/api/v2/salons/{id}", methods{
+		http.MethodGet:    pathID("id_salon", s.authorize(anyone, s.getSalonHandler)),
+		http.MethodPut:    pathID("id_salon", requireIfMatch(s.authorize(canManageSalon, s.updateSalonHandler))),
+		http.MethodPatch:  pathID("id_salon", requireIfMatch(s.authorize(canManageSalon, s.updateSalonHandler))),
+		http.MethodDelete: pathID("id_salon", requireIfMatch(s.authorize(canManageSalon, s.deleteSalonHandler))),
+	})
+	mux.Handle("/api/v2/salons/{id}/coiffeurs", methods{
+		http.MethodGet:  pathID("id_salon", s.authorize(anyone, s.getCoiffeursHandler)),
+		http.MethodPost: pathID("id_salon", s.authorize(s.canManageCoiffeur, s.addCoiffeurHandler)),
+	})
+	mux.Handle("/api/v2/salons/{id}/services", methods{
+		http.MethodGet:  pathID("id_salon", s.authorize(anyone, s.getServicesHandler)),
+		http.MethodPost: pathID("id_salon", s.authorize(s.canManageService, s.addServiceHandler)),
+	})
+	mux.Handle("/api/v2/salons/{id}/reservations", methods{
+		http.MethodGet: pathID("id_salon", s.authorize(canManageSalon, s.getReservationsHandler)),
+	})
+	mux.Handle("/api/v2/salons/{id}/availability", methods{
+		http.MethodGet: pathID("id_salon", s.authorize(anyone, s.getAvailabilityHandler)),
+	})
+
+	/// Coiffeurs
+	mux.Handle("/api/v2/coiffeurs", methods{
+		http.MethodGet:  s.authorize(anyone, s.getCoiffeursHandler),
+		http.MethodPost: s.authorize(s.canManageCoiffeur, s.addCoiffeurHandler),
+	})
+	mux.Handle("/api/v2/coiffeurs/{id}", methods{
+		http.MethodGet:    pathID("id_coiffeur", s.authorize(anyone, s.getCoiffeurHandler)),
+		http.MethodPut:    pathID("id_coiffeur", requireIfMatch(s.authorize(s.canManageCoiffeur, s.updateCoiffeurHandler))),
+		http.MethodPatch:  pathID("id_coiffeur", requireIfMatch(s.authorize(s.canManageCoiffeur, s.updateCoiffeurHandler))),
+		http.MethodDelete: pathID("id_coiffeur", requireIfMatch(s.authorize(s.canManageCoiffeur, s.deleteCoiffeurHandler))),
+	})
+	mux.Handle("/api/v2/coiffeurs/{id}/creneaux", methods{
+		http.MethodGet:  pathID("id_coiffeur", s.authorize(anyone, s.getCreneauxHandler)),
+		http.MethodPost: pathID("id_coiffeur", s.authorize(s.canManageCreneau, s.addCreneauHandler)),
+	})
+	mux.Handle("/api/v2/coiffeurs/{id}/creneaux/generate", methods{
+		http.MethodPost: pathID("id_coiffeur", s.authorize(s.canManageCreneau, s.generateCreneauxHandler)),
+	})
+	mux.Handle("/api/v2/coiffeurs/{id}/horaires", methods{
+		http.MethodGet:  pathID("id_coiffeur", s.authorize(anyone, s.getHorairesHandler)),
+		http.MethodPost: pathID("id_coiffeur", s.authorize(s.canManageHoraire, s.addHoraireHandler)),
+	})
+
+	/// Services
+	mux.Handle("/api/v2/services", methods{
+		http.MethodGet:  s.authorize(anyone, s.getServicesHandler),
+		http.MethodPost: s.authorize(s.canManageService, s.addServiceHandler),
+	})
+	mux.Handle("/api/v2/services/{id}", methods{
+		http.MethodGet:    pathID("id_service", s.authorize(anyone, s.getServiceHandler)),
+		http.MethodPut:    pathID("id_service", requireIfMatch(s.authorize(s.canManageService, s.updateServiceHandler))),
+		http.MethodDelete: pathID("id_service", requireIfMatch(s.authorize(s.canManageService, s.deleteServiceHandler))),
+	})
+
+	/// Creneaux
+	mux.Handle("/api/v2/creneaux", methods{
+		http.MethodGet:  s.authorize(anyone, s.getCreneauxHandler),
+		http.MethodPost: s.authorize(s.canManageCreneau, s.addCreneauHandler),
+	})
+	mux.Handle("/api/v2/creneaux/{id}", methods{
+		http.MethodGet:    pathID("id_creneau", s.authorize(anyone, s.getCreneauHandler)),
+		http.MethodPut:    pathID("id_creneau", requireIfMatch(s.authorize(s.canManageCreneau, s.updateCreneauHandler))),
+		http.MethodPatch:  pathID("id_creneau", requireIfMatch(s.authorize(s.canManageCreneau, s.updateCreneauHandler))),
+		http.MethodDelete: pathID("id_creneau", requireIfMatch(s.authorize(s.canManageCreneau, s.deleteCreneauHandler))),
+	})
+
+	/// Horaires
+	mux.Handle("/api/v2/horaires", methods{
+		http.MethodGet:  s.authorize(anyone, s.getHorairesHandler),
+		http.MethodPost: s.authorize(s.canManageHoraire, s.addHoraireHandler),
+	})
+	mux.Handle("/api/v2/horaires/{id}", methods{
+		http.MethodGet:    pathID("id_horaire", s.authorize(anyone, s.getHoraireHandler)),
+		http.MethodPut:    pathID("id_horaire", requireIfMatch(s.authorize(s.canManageHoraire, s.updateHoraireHandler))),
+		http.MethodDelete: pathID("id_horaire", requireIfMatch(s.authorize(s.canManageHoraire, s.deleteHoraireHandler))),
+	})
+
+	/// Reservations
+	mux.Handle("/api/v2/reservations", methods{
+		http.MethodGet:  s.authorize(adminOnly, s.getReservationsHandler),
+		http.MethodPost: s.authorize(canBook, s.addReservationHandler),
+	})
+	mux.Handle("/api/v2/reservations/{id}", methods{
+		http.MethodGet:    pathID("id_reservation", s.authorize(s.canViewReservation, s.getReservationHandler)),
+		http.MethodPut:    pathID("id_reservation", requireIfMatch(s.authorize(s.canManageReservation, s.updateReservationHandler))),
+		http.MethodPatch:  pathID("id_reservation", requireIfMatch(s.authorize(s.canManageReservation, s.updateReservationHandler))),
+		http.MethodDelete: pathID("id_reservation", requireIfMatch(s.authorize(s.canManageReservation, s.deleteReservationHandler))),
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// httpServer builds the HTTP server from the configuration.
+func (s *server) httpServer() *http.Server {
+	srv := &http.Server{
+		Addr:              s.config.Listen,
+		Handler:           s.limitBody(s.routes()),
+		ReadTimeout:       s.config.Timeouts.Read,
+		ReadHeaderTimeout: s.config.Timeouts.Read,
+		WriteTimeout:      s.config.Timeouts.Write,
+		IdleTimeout:       s.config.Timeouts.Idle,
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+	}
+	return srv
+}
+
+// shutdownSignals returns a context cancelled by the first interrupt or
+// termination signal, which starts the shutdown, and one cancelled by the
+// second, for operators who do not want to wait for the drain delay.
+func shutdownSignals() (ctx, hurry context.Context, stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	hurry, cancelHurry := context.WithCancel(context.Background())
+	go func() {
+		for _, next := range []context.CancelFunc{cancel, cancelHurry} {
+			select {
+			case <-signals:
+				next()
+			case <-hurry.Done():
+				return
+			}
+		}
+	}()
+	return ctx, hurry, func() {
+		signal.Stop(signals)
+		cancel()
+		cancelHurry()
+	}
+}
+
+// serve runs srv until ctx is cancelled. It then fails /readyz for the drain
+// delay while still serving, so load balancers take the instance out first,
+// stops accepting connections and waits up to the shutdown timeout for
+// in-flight requests, bookings included, to finish. Cancelling hurry cuts the
+// drain delay short.
+func (s *server) serve(ctx, hurry context.Context, srv *http.Server) error {
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "addr", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.draining.Store(true)
+	drain, timeout := s.config.Timeouts.Drain, s.config.Timeouts.Shutdown
+	slog.Info("draining", "delay", drain.String())
+	timer := time.NewTimer(drain)
+	select {
+	case <-timer.C:
+	case <-hurry.Done():
+		timer.Stop()
+		slog.Info("drain cut short")
+	}
+
+	slog.Info("shutting down", "timeout", timeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.Close()
+		return errors.New("shutdown deadline exceeded, remaining connections closed")
+	}
+	if err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// limitBody rejects request bodies larger than the configured maximum: at
+// once with 413 when the declared length is too large, and otherwise by
+// making reads fail past the limit.
+func (s *server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > s.config.MaxBodyBytes {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("the request body cannot exceed %d bytes", s.config.MaxBodyBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
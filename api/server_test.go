@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// On shutdown the server keeps serving, with /readyz failing, for the drain
+// delay before it refuses connections.
+func TestShutdownDrainsFirst(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := defaultConfig()
+	cfg.Listen = addr
+	cfg.Timeouts.Drain = 500 * time.Millisecond
+	s := newServer(newMemoryStore(), cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.serve(ctx, context.Background(), s.httpServer()) }()
+
+	status := func(path string) int {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for deadline := time.Now().Add(5 * time.Second); status("/readyz") != http.StatusOK; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+	}
+
+	stopped := time.Now()
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining: %d, want 503", code)
+	}
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz while draining: %d, want 200", code)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(stopped); elapsed < cfg.Timeouts.Drain {
+		t.Errorf("stopped after %s, before the %s drain delay", elapsed, cfg.Timeouts.Drain)
+	}
+	if code := status("/healthz"); code != 0 {
+		t.Errorf("/healthz after shutdown: %d, want a refused connection", code)
+	}
+}
+
+func TestHurryCutsDrainShort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := defaultConfig()
+	cfg.Listen = addr
+	cfg.Timeouts.Drain = time.Minute
+	s := newServer(newMemoryStore(), cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hurry, cancelHurry := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.serve(ctx, hurry, s.httpServer()) }()
+
+	for deadline := time.Now().Add(5 * time.Second); !s.draining.Load(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start draining")
+		}
+		if resp, err := http.Get("http://" + addr + "/readyz"); err == nil {
+			resp.Body.Close()
+			cancel()
+		}
+	}
+
+	cancelHurry()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still draining after the hurry context was cancelled")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errServiceNotFound   = errors.New("service not found")
+	errServiceNotOffered = errors.New("service not offered by this salon or coiffeur")
+)
+
+// servicesFieldErrors reports, as a field error, services that do not exist
+// or belong to another salon.
+func (s *server) servicesFieldErrors(ctx context.Context, idSalon int, services []int) ([]FieldError, error) {
+	err := s.checkSalonServices(ctx, idSalon, services)
+	if err == errServiceNotFound || err == errServiceNotOffered {
+		return []FieldError{{Field: "services", Code: "invalid", Message: err.Error()}}, nil
+	}
+	return nil, err
+}
+
+// checkSalonServices makes sure every service belongs to the salon, so a
+// coiffeur cannot declare services from another salon.
+func (s *server) checkSalonServices(ctx context.Context, idSalon int, services []int) error {
+	for _, idService := range services {
+		service, err := s.services.GetService(ctx, idService)
+		if err != nil {
+			if err == errNotFound {
+				return errServiceNotFound
+			}
+			return err
+		}
+		if service.ID_salon != idSalon {
+			return errServiceNotOffered
+		}
+	}
+	return nil
+}
+
+// SERVICES
+func (s *server) addServiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var newService Service
+	err := json.NewDecoder(r.Body).Decode(&newService)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	if !s.valid(w, r, newService, onCreate) {
+		return
+	}
+
+	err = s.services.AddService(r.Context(), &newService)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newService)
+}
+
+// getServicesHandler lists the catalogue, optionally restricted to one salon
+// with ?id_salon=.
+func (s *server) getServicesHandler(w http.ResponseWriter, r *http.Request) {
+	var idSalon int
+	if idParam := r.URL.Query().Get("id_salon"); idParam != "" {
+		var err error
+		idSalon, err = strconv.Atoi(idParam)
+		if err != nil {
+			invalidParam(w, r, "id_salon")
+			return
+		}
+	}
+
+	serviceList, err := s.services.ListServices(r.Context(), idSalon)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if serviceList == nil {
+		serviceList = []Service{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(serviceList)
+}
+
+func (s *server) getServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id_service"))
+	if err != nil {
+		invalidParam(w, r, "id_service")
+		return
+	}
+
+	service, err := s.services.GetService(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if notModified(w, r, service.Version) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(service)
+}
+
+func (s *server) updateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var updatedService Service
+	err := json.NewDecoder(r.Body).Decode(&updatedService)
+	if err != nil {
+		invalidBody(w, r, err)
+		return
+	}
+	updatedService.Version = version
+	if !s.valid(w, r, updatedService, onUpdate) {
+		return
+	}
+
+	err = s.services.UpdateService(r.Context(), &updatedService)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updatedService.Version))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updatedService)
+}
+
+// deleteServiceHandler also removes the service from the coiffeurs who
+// perform it.
+func (s *server) deleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	idParam := r.URL.Query().Get("id_service")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		invalidParam(w, r, "id_service")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err = s.services.DeleteService(r.Context(), id, version)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestDeleteBookedService(t *testing.T) {
+	eachBackend(t, func(t *testing.T, api *testAPI) {
+		b := api.bookable(30)
+		creneau := api.creneau(b.coiffeur.ID_coiffeur, testStart(), 30)
+		client := api.account(RoleClient, 0, 0)
+		api.do("POST", "/api/reservations/add", client.token, b.reservation(client.ID_client, creneau.ID_creneau)).
+			expect(t, http.StatusCreated)
+
+		api.do("DELETE", pathf("/api/v2/services/%s", b.service.ID_service), api.admin, nil, "If-Match", "*").expect(t, http.StatusConflict)
+
+		var coiffeur Coiffeur
+		api.do("GET", pathf("/api/v2/coiffeurs/%s", b.coiffeur.ID_coiffeur), api.admin, nil).
+			expect(t, http.StatusOK).decode(t, &coiffeur)
+		if !slices.Contains(coiffeur.Services, b.service.ID_service) {
+			t.Errorf("coiffeur services %v lost the booked service", coiffeur.Services)
+		}
+
+		unbooked := api.service(b.salon.ID_salon, 60)
+		api.do("DELETE", pathf("/api/v2/services/%s", unbooked.ID_service), api.admin, nil, "If-Match", "*").expect(t, http.StatusOK)
+		api.do("DELETE", pathf("/api/v2/services/%s", unbooked.ID_service), api.admin, nil, "If-Match", "*").expect(t, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sessionTTL = 24 * time.Hour
+
+var errInvalidSession = errors.New("invalid or expired session")
+
+type Session struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Client    *Client   `json:"client,omitempty"`
+}
+
+// Principal is the authenticated account behind a request. ID_salon is set
+// for salon managers and ID_coiffeur for coiffeurs.
+type Principal struct {
+	ID_client   int
+	Role        string
+	ID_salon    int
+	ID_coiffeur int
+}
+
+type sessionKey struct{}
+
+// sessionPrincipal returns the account authenticated by requireAuth.
+func sessionPrincipal(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(sessionKey{}).(Principal)
+	return principal, ok
+}
+
+// Only the SHA-256 of a token is stored, so a leaked sessions table cannot be
+// replayed.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *server) createSession(ctx context.Context, idClient int) (Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return Session{}, err
+	}
+
+	session := Session{
+		Token:     base64.RawURLEncoding.EncodeToString(buf),
+		ExpiresAt: time.Now().UTC().Add(sessionTTL).Truncate(time.Second),
+	}
+
+	err := s.sessions.AddSession(ctx, idClient, hashToken(session.Token), session.ExpiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (s *server) revokeSession(ctx context.Context, token string) error {
+	return s.sessions.RevokeSession(ctx, hashToken(token), time.Now().UTC())
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// requireAuth rejects requests without a valid bearer token and stores the
+// authenticated Principal in the request context.
+func (s *server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, http.StatusUnauthorized, "unauthenticated", "a bearer token is required")
+			return
+		}
+
+		principal, err := s.sessions.GetSessionPrincipal(r.Context(), hashToken(token), time.Now().UTC())
+		if err != nil {
+			if err != errInvalidSession {
+				internalError(w, r, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, r, http.StatusUnauthorized, "invalid_token", "the token is invalid or has expired")
+			return
+		}
+
+		setRequestUser(r.Context(), principal.ID_client)
+		next(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, principal)))
+	}
+}
+
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	err := s.revokeSession(r.Context(), bearerToken(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshHandler swaps the current token for a new one with a fresh expiry.
+func (s *server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	principal, _ := sessionPrincipal(r.Context())
+	session, err := s.createSession(r.Context(), principal.ID_client)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	err = s.revokeSession(r.Context(), bearerToken(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
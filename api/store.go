@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Errors shared by every store implementation. Handlers map them to status
+// codes in writeStoreError.
+var (
+	errNotFound         = errors.New("not found")
+	errReferenced       = errors.New("still referenced by other rows")
+	errMissingReference = errors.New("references a missing row")
+	// A coiffeur cannot have two creneaux starting at the same time, and
+	// two clients cannot share an email.
+	errDuplicate = errors.New("duplicates an existing row")
+	// Clients, salons, coiffeurs, services, horaires, creneaux and
+	// reservations carry a version.
+	// Their updates and deletes take the expected version, 0 meaning any,
+	// and fail with errVersionMismatch when the row has moved on; updates
+	// set the new version in the entity they are given.
+	errVersionMismatch = errors.New("changed since the given version")
+)
+
+// Store is the whole persistence layer. The SQL and in-memory
+// implementations provide every entity store at once; handlers only see the
+// narrower interfaces through server.
+type Store interface {
+	ClientStore
+	SalonStore
+	CoiffeurStore
+	ServiceStore
+	HoraireStore
+	CreneauStore
+	ReservationStore
+	SessionStore
+}
+
+var (
+	_ Store = (*sqlStore)(nil)
+	_ Store = (*memoryStore)(nil)
+)
+
+// ClientStore keeps accounts. Client.Password always holds a bcrypt hash (or a
+// legacy plaintext password) and is only returned by GetClientByEmail.
+type ClientStore interface {
+	ListClients(ctx context.Context, f ClientFilter, o ListOptions) (Page[Client], error)
+	GetClient(ctx context.Context, id int) (Client, error)
+	GetClientByEmail(ctx context.Context, email string) (Client, error)
+	AddClient(ctx context.Context, client *Client) error
+	// UpdateClient keeps the current password when client.Password is empty.
+	UpdateClient(ctx context.Context, client *Client) error
+	SetClientPassword(ctx context.Context, id int, hash string) error
+	SetClientRole(ctx context.Context, id int, role string, idSalon, idCoiffeur int) error
+	DeleteClient(ctx context.Context, id, version int) error
+}
+
+type SalonStore interface {
+	ListSalons(ctx context.Context, f SalonFilter, o ListOptions) (Page[Salon], error)
+	GetSalon(ctx context.Context, id int) (Salon, error)
+	AddSalon(ctx context.Context, salon *Salon) error
+	UpdateSalon(ctx context.Context, salon *Salon) error
+	DeleteSalon(ctx context.Context, id, version int) error
+}
+
+type CoiffeurStore interface {
+	ListCoiffeurs(ctx context.Context, f CoiffeurFilter, o ListOptions) (Page[Coiffeur], error)
+	GetCoiffeur(ctx context.Context, id int) (Coiffeur, error)
+	AddCoiffeur(ctx context.Context, coiffeur *Coiffeur) error
+	// UpdateCoiffeur keeps the current services when coiffeur.Services is nil.
+	UpdateCoiffeur(ctx context.Context, coiffeur *Coiffeur) error
+	DeleteCoiffeur(ctx context.Context, id, version int) error
+}
+
+type ServiceStore interface {
+	// ListServices lists the services of one salon, or all of them when
+	// idSalon is 0.
+	ListServices(ctx context.Context, idSalon int) ([]Service, error)
+	GetService(ctx context.Context, id int) (Service, error)
+	AddService(ctx context.Context, service *Service) error
+	UpdateService(ctx context.Context, service *Service) error
+	DeleteService(ctx context.Context, id, version int) error
+}
+
+type HoraireStore interface {
+	// ListHoraires lists the working hours of one coiffeur, or all of them
+	// when idCoiffeur is 0.
+	ListHoraires(ctx context.Context, idCoiffeur int) ([]Horaire, error)
+	GetHoraire(ctx context.Context, id int) (Horaire, error)
+	AddHoraire(ctx context.Context, horaire *Horaire) error
+	UpdateHoraire(ctx context.Context, horaire *Horaire) error
+	DeleteHoraire(ctx context.Context, id, version int) error
+}
+
+// CreneauStore keeps slots with UTC start and end times; handlers convert them
+// to the salon's zone.
+type CreneauStore interface {
+	ListCreneaux(ctx context.Context, f CreneauFilter, o ListOptions) (Page[Creneau], error)
+	GetCreneau(ctx context.Context, id int) (Creneau, error)
+	AddCreneau(ctx context.Context, creneau *Creneau) error
+	// UpdateCreneau and DeleteCreneau fail with errReferenced on a creneau
+	// a reservation took, including those following its first one.
+	UpdateCreneau(ctx context.Context, creneau *Creneau) error
+	DeleteCreneau(ctx context.Context, id, version int) error
+	// FreeCreneaux returns the available creneaux of a coiffeur starting in
+	// [from, to), in chronological order.
+	FreeCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error)
+	// CoiffeurCreneaux returns the creneaux of a coiffeur, free or booked,
+	// that overlap [from, to), in chronological order.
+	CoiffeurCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error)
+}
+
+type ReservationStore interface {
+	ListReservations(ctx context.Context, f ReservationFilter, o ListOptions) (Page[Reservation], error)
+	GetReservation(ctx context.Context, id int) (Reservation, error)
+	// BookReservation atomically checks the client, service and creneaux,
+	// inserts the reservation and marks as taken its creneau and the ones
+	// following it that the service's duration covers. Concurrent bookings
+	// of overlapping creneaux succeed at most once; the others get
+	// errCreneauUnavailable. The returned reservation carries its id and the
+	// service's duration and price.
+	BookReservation(ctx context.Context, reservation Reservation) (Reservation, error)
+	// UpdateReservation moves the reservation when its creneau or coiffeur
+	// changes, freeing the creneaux it held and taking the new ones as
+	// BookReservation would, with the same errors.
+	UpdateReservation(ctx context.Context, reservation *Reservation) error
+	// DeleteReservation frees the creneaux the reservation took.
+	DeleteReservation(ctx context.Context, id, version int) error
+	// ListClientReservations returns a client's reservations whose creneau
+	// starts at or after now (upcoming) or before it (past).
+	ListClientReservations(ctx context.Context, idClient int, now time.Time, upcoming bool) ([]Reservation, error)
+}
+
+// SessionStore keeps login sessions by SHA-256 of their token.
+type SessionStore interface {
+	AddSession(ctx context.Context, idClient int, tokenHash string, expiresAt time.Time) error
+	// GetSessionPrincipal returns the account owning a session that is
+	// neither expired at now nor revoked, or errInvalidSession.
+	GetSessionPrincipal(ctx context.Context, tokenHash string, now time.Time) (Principal, error)
+	RevokeSession(ctx context.Context, tokenHash string, now time.Time) error
+}
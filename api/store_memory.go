@@ -0,0 +1,1018 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memorySession struct {
+	idClient  int
+	expiresAt time.Time
+	revoked   bool
+}
+
+// memoryStore implements Store in process memory, for tests and local runs
+// without a database. It enforces the same foreign keys as the migrations: rows
+// pointing to a missing parent are refused with errMissingReference, parents
+// still referenced with errReferenced, and service links, horaires and
+// sessions go away with their owner.
+type memoryStore struct {
+	mu           sync.Mutex
+	nextID       int
+	clients      map[int]Client
+	salons       map[int]Salon
+	coiffeurs    map[int]Coiffeur
+	services     map[int]Service
+	horaires     map[int]Horaire
+	creneaux     map[int]Creneau
+	reservations map[int]Reservation
+	sessions     map[string]memorySession
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		nextID:       1,
+		clients:      map[int]Client{},
+		salons:       map[int]Salon{},
+		coiffeurs:    map[int]Coiffeur{},
+		services:     map[int]Service{},
+		horaires:     map[int]Horaire{},
+		creneaux:     map[int]Creneau{},
+		reservations: map[int]Reservation{},
+		sessions:     map[string]memorySession{},
+	}
+}
+
+// newID hands out ids from one counter shared by every entity, which is
+// enough for tests and keeps ids unique across tables.
+func (s *memoryStore) newID() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// values returns the rows of a map ordered by id, like a primary key scan.
+func values[T any](rows map[int]T) []T {
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var list []T
+	for _, id := range ids {
+		list = append(list, rows[id])
+	}
+	return list
+}
+
+func exists[T any](rows map[int]T, id int) bool {
+	_, ok := rows[id]
+	return ok
+}
+
+// optional reports whether a nullable reference is either unset or valid.
+func optional[T any](rows map[int]T, id int) bool {
+	return id == 0 || exists(rows, id)
+}
+
+// checkVersion compares the version of a row with the one a write expects, 0
+// accepting any.
+func checkVersion(current, expected int) error {
+	if expected != 0 && expected != current {
+		return errVersionMismatch
+	}
+	return nil
+}
+
+// CLIENTS
+func (s *memoryStore) ListClients(ctx context.Context, f ClientFilter, o ListOptions) (Page[Client], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var clientList []Client
+	for _, client := range values(s.clients) {
+		if (f.Role != "" && client.Role != f.Role) || (f.Email != "" && client.Email != f.Email) {
+			continue
+		}
+		client.Password = ""
+		clientList = append(clientList, client)
+	}
+	return pageOf(clientList, clientID, clientSorts, o), nil
+}
+
+func (s *memoryStore) GetClient(ctx context.Context, id int) (Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return Client{}, errNotFound
+	}
+	client.Password = ""
+	return client, nil
+}
+
+func (s *memoryStore) GetClientByEmail(ctx context.Context, email string) (Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range values(s.clients) {
+		if client.Email == email {
+			return client, nil
+		}
+	}
+	return Client{}, errNotFound
+}
+
+func (s *memoryStore) AddClient(ctx context.Context, client *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(*client) {
+		return errDuplicate
+	}
+	client.ID_client = s.newID()
+	client.Role = RoleClient
+	client.ID_salon = 0
+	client.ID_coiffeur = 0
+	client.Version = 1
+	s.clients[client.ID_client] = *client
+	return nil
+}
+
+func (s *memoryStore) UpdateClient(ctx context.Context, client *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.clients[client.ID_client]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, client.Version); err != nil {
+		return err
+	}
+	if s.emailTaken(*client) {
+		return errDuplicate
+	}
+	current.Firstname = client.Firstname
+	current.Lastname = client.Lastname
+	current.Email = client.Email
+	if client.Password != "" {
+		current.Password = client.Password
+	}
+	current.Version++
+	s.clients[client.ID_client] = current
+	client.Version = current.Version
+	return nil
+}
+
+// emailTaken tells whether another client has the email of client, which the
+// unique index of the SQL schema refuses.
+func (s *memoryStore) emailTaken(client Client) bool {
+	for _, other := range s.clients {
+		if other.ID_client != client.ID_client && other.Email == client.Email {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) SetClientPassword(ctx context.Context, id int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return errNotFound
+	}
+	client.Password = hash
+	s.clients[id] = client
+	return nil
+}
+
+func (s *memoryStore) SetClientRole(ctx context.Context, id int, role string, idSalon, idCoiffeur int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return errNotFound
+	}
+	client.Role = role
+	client.ID_salon = idSalon
+	client.ID_coiffeur = idCoiffeur
+	client.Version++
+	s.clients[id] = client
+	return nil
+}
+
+func (s *memoryStore) DeleteClient(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(client.Version, version); err != nil {
+		return err
+	}
+	for _, reservation := range s.reservations {
+		if reservation.ID_client == id {
+			return errReferenced
+		}
+	}
+	for hash, session := range s.sessions {
+		if session.idClient == id {
+			delete(s.sessions, hash)
+		}
+	}
+	delete(s.clients, id)
+	return nil
+}
+
+// SALONS
+func (s *memoryStore) ListSalons(ctx context.Context, f SalonFilter, o ListOptions) (Page[Salon], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var salonList []Salon
+	for _, salon := range values(s.salons) {
+		if strings.Contains(strings.ToLower(salon.Name), strings.ToLower(f.Name)) {
+			salonList = append(salonList, salon)
+		}
+	}
+	return pageOf(salonList, salonID, salonSorts, o), nil
+}
+
+func (s *memoryStore) GetSalon(ctx context.Context, id int) (Salon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	salon, ok := s.salons[id]
+	if !ok {
+		return Salon{}, errNotFound
+	}
+	return salon, nil
+}
+
+func (s *memoryStore) AddSalon(ctx context.Context, salon *Salon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	salon.ID_salon = s.newID()
+	salon.Version = 1
+	s.salons[salon.ID_salon] = *salon
+	return nil
+}
+
+func (s *memoryStore) UpdateSalon(ctx context.Context, salon *Salon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.salons[salon.ID_salon]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, salon.Version); err != nil {
+		return err
+	}
+	salon.Version = current.Version + 1
+	s.salons[salon.ID_salon] = *salon
+	return nil
+}
+
+func (s *memoryStore) DeleteSalon(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	salon, ok := s.salons[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(salon.Version, version); err != nil {
+		return err
+	}
+	for _, coiffeur := range s.coiffeurs {
+		if coiffeur.ID_salon == id {
+			return errReferenced
+		}
+	}
+	for _, service := range s.services {
+		if service.ID_salon == id {
+			return errReferenced
+		}
+	}
+	for _, reservation := range s.reservations {
+		if reservation.ID_salon == id {
+			return errReferenced
+		}
+	}
+	delete(s.salons, id)
+	return nil
+}
+
+// COIFFEURS
+func (s *memoryStore) checkServices(services []int) error {
+	for _, idService := range services {
+		if !exists(s.services, idService) {
+			return errMissingReference
+		}
+	}
+	return nil
+}
+
+// uniqueServices sorts and deduplicates a services list, as the
+// coiffeur_services primary key does.
+func uniqueServices(services []int) []int {
+	if services == nil {
+		return nil
+	}
+	unique := []int{}
+	seen := map[int]bool{}
+	for _, id := range services {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
+func (s *memoryStore) ListCoiffeurs(ctx context.Context, f CoiffeurFilter, o ListOptions) (Page[Coiffeur], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var coiffeurList []Coiffeur
+	for _, coiffeur := range values(s.coiffeurs) {
+		if f.ID_salon != 0 && coiffeur.ID_salon != f.ID_salon {
+			continue
+		}
+		if f.ID_service != 0 && !performs(coiffeur, f.ID_service) {
+			continue
+		}
+		coiffeurList = append(coiffeurList, coiffeur)
+	}
+	return pageOf(coiffeurList, coiffeurID, coiffeurSorts, o), nil
+}
+
+func (s *memoryStore) GetCoiffeur(ctx context.Context, id int) (Coiffeur, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coiffeur, ok := s.coiffeurs[id]
+	if !ok {
+		return Coiffeur{}, errNotFound
+	}
+	return coiffeur, nil
+}
+
+func (s *memoryStore) AddCoiffeur(ctx context.Context, coiffeur *Coiffeur) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !optional(s.salons, coiffeur.ID_salon) {
+		return errMissingReference
+	}
+	if err := s.checkServices(coiffeur.Services); err != nil {
+		return err
+	}
+	coiffeur.ID_coiffeur = s.newID()
+	coiffeur.Version = 1
+	stored := *coiffeur
+	stored.Services = uniqueServices(coiffeur.Services)
+	s.coiffeurs[coiffeur.ID_coiffeur] = stored
+	return nil
+}
+
+func (s *memoryStore) UpdateCoiffeur(ctx context.Context, coiffeur *Coiffeur) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.coiffeurs[coiffeur.ID_coiffeur]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, coiffeur.Version); err != nil {
+		return err
+	}
+	if !optional(s.salons, coiffeur.ID_salon) {
+		return errMissingReference
+	}
+	stored := *coiffeur
+	if coiffeur.Services == nil {
+		stored.Services = current.Services
+	} else {
+		if err := s.checkServices(coiffeur.Services); err != nil {
+			return err
+		}
+		stored.Services = uniqueServices(coiffeur.Services)
+	}
+	stored.Version = current.Version + 1
+	s.coiffeurs[coiffeur.ID_coiffeur] = stored
+	coiffeur.Version = stored.Version
+	return nil
+}
+
+func (s *memoryStore) DeleteCoiffeur(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coiffeur, ok := s.coiffeurs[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(coiffeur.Version, version); err != nil {
+		return err
+	}
+	for _, creneau := range s.creneaux {
+		if creneau.ID_coiffeur == id {
+			return errReferenced
+		}
+	}
+	for _, reservation := range s.reservations {
+		if reservation.ID_coiffeur == id {
+			return errReferenced
+		}
+	}
+	for idHoraire, horaire := range s.horaires {
+		if horaire.ID_coiffeur == id {
+			delete(s.horaires, idHoraire)
+		}
+	}
+	delete(s.coiffeurs, id)
+	return nil
+}
+
+// SERVICES
+func (s *memoryStore) ListServices(ctx context.Context, idSalon int) ([]Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var serviceList []Service
+	for _, service := range values(s.services) {
+		if idSalon == 0 || service.ID_salon == idSalon {
+			serviceList = append(serviceList, service)
+		}
+	}
+	return serviceList, nil
+}
+
+func (s *memoryStore) GetService(ctx context.Context, id int) (Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	service, ok := s.services[id]
+	if !ok {
+		return Service{}, errNotFound
+	}
+	return service, nil
+}
+
+func (s *memoryStore) AddService(ctx context.Context, service *Service) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !optional(s.salons, service.ID_salon) {
+		return errMissingReference
+	}
+	service.ID_service = s.newID()
+	service.Version = 1
+	s.services[service.ID_service] = *service
+	return nil
+}
+
+func (s *memoryStore) UpdateService(ctx context.Context, service *Service) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.services[service.ID_service]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, service.Version); err != nil {
+		return err
+	}
+	if !optional(s.salons, service.ID_salon) {
+		return errMissingReference
+	}
+	service.Version = current.Version + 1
+	s.services[service.ID_service] = *service
+	return nil
+}
+
+func (s *memoryStore) DeleteService(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	service, ok := s.services[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(service.Version, version); err != nil {
+		return err
+	}
+	for _, reservation := range s.reservations {
+		if reservation.ID_service == id {
+			return errReferenced
+		}
+	}
+	for idCoiffeur, coiffeur := range s.coiffeurs {
+		var services []int
+		for _, idService := range coiffeur.Services {
+			if idService != id {
+				services = append(services, idService)
+			}
+		}
+		coiffeur.Services = services
+		s.coiffeurs[idCoiffeur] = coiffeur
+	}
+	delete(s.services, id)
+	return nil
+}
+
+// HORAIRES
+func (s *memoryStore) ListHoraires(ctx context.Context, idCoiffeur int) ([]Horaire, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var horaireList []Horaire
+	for _, horaire := range values(s.horaires) {
+		if idCoiffeur == 0 || horaire.ID_coiffeur == idCoiffeur {
+			horaireList = append(horaireList, horaire)
+		}
+	}
+	sort.SliceStable(horaireList, func(i, j int) bool {
+		a, b := horaireList[i], horaireList[j]
+		if a.ID_coiffeur != b.ID_coiffeur {
+			return a.ID_coiffeur < b.ID_coiffeur
+		}
+		if a.Weekday != b.Weekday {
+			return a.Weekday < b.Weekday
+		}
+		return a.Start < b.Start
+	})
+	return horaireList, nil
+}
+
+func (s *memoryStore) GetHoraire(ctx context.Context, id int) (Horaire, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	horaire, ok := s.horaires[id]
+	if !ok {
+		return Horaire{}, errNotFound
+	}
+	return horaire, nil
+}
+
+func (s *memoryStore) AddHoraire(ctx context.Context, horaire *Horaire) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !exists(s.coiffeurs, horaire.ID_coiffeur) {
+		return errMissingReference
+	}
+	horaire.ID_horaire = s.newID()
+	horaire.Version = 1
+	s.horaires[horaire.ID_horaire] = *horaire
+	return nil
+}
+
+func (s *memoryStore) UpdateHoraire(ctx context.Context, horaire *Horaire) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.horaires[horaire.ID_horaire]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, horaire.Version); err != nil {
+		return err
+	}
+	if !exists(s.coiffeurs, horaire.ID_coiffeur) {
+		return errMissingReference
+	}
+	horaire.Version = current.Version + 1
+	s.horaires[horaire.ID_horaire] = *horaire
+	return nil
+}
+
+func (s *memoryStore) DeleteHoraire(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	horaire, ok := s.horaires[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(horaire.Version, version); err != nil {
+		return err
+	}
+	delete(s.horaires, id)
+	return nil
+}
+
+// CRENEAUX
+// sortedCreneaux returns the creneaux accepted by keep in chronological order.
+func (s *memoryStore) sortedCreneaux(keep func(Creneau) bool) []Creneau {
+	var creneauList []Creneau
+	for _, creneau := range values(s.creneaux) {
+		if keep(creneau) {
+			creneauList = append(creneauList, creneau)
+		}
+	}
+	sort.SliceStable(creneauList, func(i, j int) bool {
+		return creneauList[i].Start.Before(creneauList[j].Start)
+	})
+	return creneauList
+}
+
+func (s *memoryStore) ListCreneaux(ctx context.Context, f CreneauFilter, o ListOptions) (Page[Creneau], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creneauList := s.sortedCreneaux(func(c Creneau) bool {
+		return (f.ID_coiffeur == 0 || c.ID_coiffeur == f.ID_coiffeur) &&
+			(f.From.IsZero() || !c.Start.Before(f.From)) &&
+			(f.To.IsZero() || c.Start.Before(f.To)) &&
+			(f.Available == nil || c.Availability == *f.Available)
+	})
+	return pageOf(creneauList, creneauID, creneauSorts, o), nil
+}
+
+func (s *memoryStore) GetCreneau(ctx context.Context, id int) (Creneau, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creneau, ok := s.creneaux[id]
+	if !ok {
+		return Creneau{}, errNotFound
+	}
+	return creneau, nil
+}
+
+func (s *memoryStore) AddCreneau(ctx context.Context, creneau *Creneau) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !exists(s.coiffeurs, creneau.ID_coiffeur) {
+		return errMissingReference
+	}
+	if s.startTaken(*creneau) {
+		return errDuplicate
+	}
+	creneau.ID_creneau = s.newID()
+	creneau.Version = 1
+	stored := *creneau
+	stored.Start = stored.Start.UTC()
+	stored.End = stored.End.UTC()
+	s.creneaux[creneau.ID_creneau] = stored
+	return nil
+}
+
+func (s *memoryStore) UpdateCreneau(ctx context.Context, creneau *Creneau) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.creneaux[creneau.ID_creneau]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, creneau.Version); err != nil {
+		return err
+	}
+	if s.held(current) {
+		return errReferenced
+	}
+	if !exists(s.coiffeurs, creneau.ID_coiffeur) {
+		return errMissingReference
+	}
+	if s.startTaken(*creneau) {
+		return errDuplicate
+	}
+	creneau.Version = current.Version + 1
+	stored := *creneau
+	stored.Start = stored.Start.UTC()
+	stored.End = stored.End.UTC()
+	s.creneaux[creneau.ID_creneau] = stored
+	return nil
+}
+
+// held tells whether a reservation took creneau, as its first creneau or
+// as one of those its span goes on with.
+func (s *memoryStore) held(creneau Creneau) bool {
+	for _, reservation := range s.reservations {
+		first, ok := s.creneaux[reservation.ID_creneau]
+		if ok && spanHolds(first, time.Duration(reservation.Duration)*time.Minute, creneau) {
+			return true
+		}
+	}
+	return false
+}
+
+// startTaken tells whether another creneau of the same coiffeur starts when
+// creneau does, which the unique index of the SQL schema refuses.
+func (s *memoryStore) startTaken(creneau Creneau) bool {
+	for _, other := range s.creneaux {
+		if other.ID_creneau != creneau.ID_creneau && other.ID_coiffeur == creneau.ID_coiffeur && other.Start.Equal(creneau.Start) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) DeleteCreneau(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creneau, ok := s.creneaux[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(creneau.Version, version); err != nil {
+		return err
+	}
+	if s.held(creneau) {
+		return errReferenced
+	}
+	delete(s.creneaux, id)
+	return nil
+}
+
+func (s *memoryStore) FreeCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedCreneaux(func(creneau Creneau) bool {
+		return creneau.ID_coiffeur == idCoiffeur && creneau.Availability &&
+			!creneau.Start.Before(from) && creneau.Start.Before(to)
+	}), nil
+}
+
+func (s *memoryStore) CoiffeurCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedCreneaux(func(creneau Creneau) bool {
+		return creneau.ID_coiffeur == idCoiffeur && creneau.Start.Before(to) && creneau.End.After(from)
+	}), nil
+}
+
+// RESERVATIONS
+func (s *memoryStore) checkReservation(reservation Reservation) error {
+	if !optional(s.clients, reservation.ID_client) ||
+		!exists(s.salons, reservation.ID_salon) ||
+		!exists(s.coiffeurs, reservation.ID_coiffeur) ||
+		!exists(s.creneaux, reservation.ID_creneau) ||
+		!optional(s.services, reservation.ID_service) {
+		return errMissingReference
+	}
+	return nil
+}
+
+func (s *memoryStore) ListReservations(ctx context.Context, f ReservationFilter, o ListOptions) (Page[Reservation], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reservationList []Reservation
+	for _, reservation := range values(s.reservations) {
+		if (f.ID_client != 0 && reservation.ID_client != f.ID_client) ||
+			(f.ID_salon != 0 && reservation.ID_salon != f.ID_salon) ||
+			(f.ID_coiffeur != 0 && reservation.ID_coiffeur != f.ID_coiffeur) {
+			continue
+		}
+		if f.Status != "" {
+			creneau, ok := s.creneaux[reservation.ID_creneau]
+			if !ok || creneau.Start.Before(f.Now) != (f.Status == statusPast) {
+				continue
+			}
+		}
+		reservationList = append(reservationList, reservation)
+	}
+	return pageOf(reservationList, reservationID, reservationSorts, o), nil
+}
+
+func (s *memoryStore) GetReservation(ctx context.Context, id int) (Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reservation, ok := s.reservations[id]
+	if !ok {
+		return Reservation{}, errNotFound
+	}
+	return reservation, nil
+}
+
+// BookReservation runs every check and both writes under the store lock, which
+// gives the same guarantee as the row lock taken by the SQL store.
+func (s *memoryStore) BookReservation(ctx context.Context, reservation Reservation) (Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !exists(s.clients, reservation.ID_client) {
+		return reservation, errClientNotFound
+	}
+
+	service, ok := s.services[reservation.ID_service]
+	if !ok {
+		return reservation, errServiceNotFound
+	}
+	if service.ID_salon != reservation.ID_salon {
+		return reservation, errServiceNotOffered
+	}
+	reservation.Duration = service.Duration
+	reservation.Price = service.Price
+
+	if !performs(s.coiffeurs[reservation.ID_coiffeur], reservation.ID_service) {
+		return reservation, errServiceNotOffered
+	}
+
+	span, err := s.span(reservation.ID_creneau, time.Duration(reservation.Duration)*time.Minute)
+	if err != nil {
+		return reservation, err
+	}
+	if err := checkSpan(span, reservation); err != nil {
+		return reservation, err
+	}
+
+	if err := s.checkReservation(reservation); err != nil {
+		return reservation, err
+	}
+
+	reservation.ID_reservation = s.newID()
+	reservation.Version = 1
+	s.reservations[reservation.ID_reservation] = reservation
+	s.setAvailability(span, false)
+	return reservation, nil
+}
+
+// span returns the span bookingSpan finds for a booking of duration starting
+// with the creneau idCreneau.
+func (s *memoryStore) span(idCreneau int, duration time.Duration) ([]Creneau, error) {
+	first, ok := s.creneaux[idCreneau]
+	if !ok {
+		return nil, errCreneauNotFound
+	}
+	next := s.sortedCreneaux(func(creneau Creneau) bool {
+		return creneau.ID_coiffeur == first.ID_coiffeur &&
+			creneau.Start.After(first.Start) && creneau.Start.Before(first.Start.Add(duration))
+	})
+	span, _ := bookingSpan(append([]Creneau{first}, next...), duration)
+	return span, nil
+}
+
+func (s *memoryStore) setAvailability(span []Creneau, availability bool) {
+	for _, creneau := range span {
+		creneau.Availability = availability
+		creneau.Version++
+		s.creneaux[creneau.ID_creneau] = creneau
+	}
+}
+
+func (s *memoryStore) UpdateReservation(ctx context.Context, reservation *Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.reservations[reservation.ID_reservation]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(current.Version, reservation.Version); err != nil {
+		return err
+	}
+	if reservation.ID_service != current.ID_service {
+		return errServiceChanged
+	}
+	moved := current
+	current.ID_client = reservation.ID_client
+	current.ID_salon = reservation.ID_salon
+	current.ID_coiffeur = reservation.ID_coiffeur
+	current.ID_creneau = reservation.ID_creneau
+	if err := s.checkReservation(current); err != nil {
+		return err
+	}
+	if s.coiffeurs[current.ID_coiffeur].ID_salon != current.ID_salon {
+		return errCoiffeurOtherSalon
+	}
+	if moved.ID_creneau != current.ID_creneau || moved.ID_coiffeur != current.ID_coiffeur {
+		if err := s.moveReservation(moved, current.ID_coiffeur, current.ID_creneau); err != nil {
+			return err
+		}
+	}
+	current.Version++
+	s.reservations[reservation.ID_reservation] = current
+	reservation.Version = current.Version
+	return nil
+}
+
+// moveReservation frees the creneaux taken by reservation and takes those of
+// the coiffeur idCoiffeur from idCreneau on, or changes nothing when they
+// cannot be booked.
+func (s *memoryStore) moveReservation(reservation Reservation, idCoiffeur, idCreneau int) error {
+	duration := time.Duration(reservation.Duration) * time.Minute
+	held, _ := s.span(reservation.ID_creneau, duration)
+	s.setAvailability(held, true)
+
+	err := func() error {
+		if reservation.ID_service != 0 && !performs(s.coiffeurs[idCoiffeur], reservation.ID_service) {
+			return errServiceNotOffered
+		}
+		reservation.ID_coiffeur = idCoiffeur
+		span, err := s.span(idCreneau, duration)
+		if err != nil {
+			return err
+		}
+		if err := checkSpan(span, reservation); err != nil {
+			return err
+		}
+		s.setAvailability(span, false)
+		return nil
+	}()
+	if err != nil {
+		for _, creneau := range held {
+			s.creneaux[creneau.ID_creneau] = creneau
+		}
+	}
+	return err
+}
+
+func (s *memoryStore) DeleteReservation(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reservation, ok := s.reservations[id]
+	if !ok {
+		return errNotFound
+	}
+	if err := checkVersion(reservation.Version, version); err != nil {
+		return err
+	}
+	span, _ := s.span(reservation.ID_creneau, time.Duration(reservation.Duration)*time.Minute)
+	delete(s.reservations, id)
+	s.setAvailability(span, true)
+	return nil
+}
+
+func (s *memoryStore) ListClientReservations(ctx context.Context, idClient int, now time.Time, upcoming bool) ([]Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reservationList []Reservation
+	for _, reservation := range values(s.reservations) {
+		creneau, ok := s.creneaux[reservation.ID_creneau]
+		if reservation.ID_client != idClient || !ok {
+			continue
+		}
+		if creneau.Start.Before(now) != upcoming {
+			reservationList = append(reservationList, reservation)
+		}
+	}
+	sort.SliceStable(reservationList, func(i, j int) bool {
+		return s.creneaux[reservationList[i].ID_creneau].Start.Before(s.creneaux[reservationList[j].ID_creneau].Start)
+	})
+	return reservationList, nil
+}
+
+// SESSIONS
+func (s *memoryStore) AddSession(ctx context.Context, idClient int, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !exists(s.clients, idClient) {
+		return errMissingReference
+	}
+	s.sessions[tokenHash] = memorySession{idClient: idClient, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryStore) GetSessionPrincipal(ctx context.Context, tokenHash string, now time.Time) (Principal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[tokenHash]
+	if !ok || session.revoked || !session.expiresAt.After(now) {
+		return Principal{}, errInvalidSession
+	}
+	client, ok := s.clients[session.idClient]
+	if !ok {
+		return Principal{}, errInvalidSession
+	}
+	return Principal{ID_client: client.ID_client, Role: client.Role, ID_salon: client.ID_salon, ID_coiffeur: client.ID_coiffeur}, nil
+}
+
+func (s *memoryStore) RevokeSession(ctx context.Context, tokenHash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[tokenHash]; ok {
+		session.revoked = true
+		s.sessions[tokenHash] = session
+	}
+	return nil
+}
@@ -0,0 +1,1054 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// sqlStore implements Store on the schema created by the migrations of its
+// dialect.
+type sqlStore struct {
+	db *sql.DB
+	d  *dialect
+}
+
+func newSQLStore(db *sql.DB, d *dialect) *sqlStore {
+	return &sqlStore{db: db, d: d}
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlConn runs queries written with ? placeholders on a connection or a
+// transaction, rebinding them for the dialect and mapping write errors to the
+// store's sentinel errors.
+type sqlConn struct {
+	q queryer
+	d *dialect
+}
+
+func (c sqlConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, c.d.name, query)
+	result, err := c.q.ExecContext(ctx, c.d.rebind(query), args...)
+	endQuerySpan(span, err)
+	if err != nil {
+		return nil, c.fail(ctx, err, query)
+	}
+	return result, nil
+}
+
+func (c sqlConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, c.d.name, query)
+	rows, err := c.q.QueryContext(ctx, c.d.rebind(query), args...)
+	endQuerySpan(span, err)
+	if err != nil {
+		return nil, c.fail(ctx, err, query)
+	}
+	return rows, nil
+}
+
+func (c sqlConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, c.d.name, query)
+	row := c.q.QueryRowContext(ctx, c.d.rebind(query), args...)
+	endQuerySpan(span, row.Err())
+	return row
+}
+
+// fail maps err to the store's errors and logs, with the request ID of ctx,
+// the ones that are not expected outcomes such as a missing row.
+func (c sqlConn) fail(ctx context.Context, err error, query string) error {
+	storeErr := c.d.storeError(err, query)
+	if storeErr == err && !errors.Is(err, context.Canceled) {
+		slog.ErrorContext(ctx, "query failed", "query", strings.Join(strings.Fields(query), " "), "err", err)
+	}
+	return storeErr
+}
+
+// insert runs an INSERT and returns the id generated for idColumn.
+func (c sqlConn) insert(ctx context.Context, idColumn, query string, args ...any) (int, error) {
+	if c.d.returning {
+		var id int
+		err := c.QueryRowContext(ctx, query+" RETURNING "+idColumn, args...).Scan(&id)
+		if err != nil {
+			return 0, c.fail(ctx, err, query)
+		}
+		return id, nil
+	}
+
+	result, err := c.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqlStore) conn() sqlConn {
+	return sqlConn{q: s.db, d: s.d}
+}
+
+func (s *sqlStore) begin(ctx context.Context) (*sql.Tx, sqlConn, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	return tx, sqlConn{q: tx, d: s.d}, err
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	return err
+}
+
+// execOne runs a statement that targets a single row by id and reports
+// errNotFound when that row does not exist. MySQL counts unchanged rows as
+// unaffected, so a zero count is double-checked with exists.
+func (s *sqlStore) execOne(ctx context.Context, exists, query string, args ...any) error {
+	result, err := s.conn().ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var found int
+	id := args[len(args)-1]
+	return notFound(s.conn().QueryRowContext(ctx, exists, id).Scan(&found))
+}
+
+// VERSIONS
+// versioned applies change to the row of table with the given id in a
+// transaction that first locks the row and checks its version, 0 accepting
+// any. It returns the version the row had.
+func (s *sqlStore) versioned(ctx context.Context, table, idColumn string, id, version int, change func(c sqlConn) error) (int, error) {
+	tx, c, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	row := c.QueryRowContext(ctx, "SELECT version FROM "+table+" WHERE "+idColumn+"=?"+s.d.forUpdate, id)
+	if err := row.Scan(&current); err != nil {
+		return 0, notFound(err)
+	}
+	if version != 0 && version != current {
+		return 0, errVersionMismatch
+	}
+	if err := change(c); err != nil {
+		return 0, err
+	}
+	return current, tx.Commit()
+}
+
+// updateVersioned runs query, an UPDATE of one versioned row that must bump
+// its version, and returns the new version.
+func (s *sqlStore) updateVersioned(ctx context.Context, table, idColumn string, id, version int, query string, args ...any) (int, error) {
+	current, err := s.versioned(ctx, table, idColumn, id, version, func(c sqlConn) error {
+		_, err := c.ExecContext(ctx, query, args...)
+		return err
+	})
+	return current + 1, err
+}
+
+func (s *sqlStore) deleteVersioned(ctx context.Context, table, idColumn string, id, version int) error {
+	_, err := s.versioned(ctx, table, idColumn, id, version, func(c sqlConn) error {
+		_, err := c.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+idColumn+"=?", id)
+		return err
+	})
+	return err
+}
+
+// LISTS
+// listQuery is a SELECT kept in parts, so that queryPage can count its rows
+// and add the conditions and order of a page.
+type listQuery struct {
+	columns  string
+	from     string
+	idColumn string
+	where    []string
+	args     []any
+}
+
+func (q *listQuery) filter(condition string, args ...any) {
+	q.where = append(q.where, condition)
+	q.args = append(q.args, args...)
+}
+
+// queryPage runs q for the page selected by o. Pages are keyset-based: they
+// continue after the sort value and id of the cursor, so rows inserted or
+// deleted meanwhile do not shift them.
+func queryPage[T any](ctx context.Context, c sqlConn, q listQuery, sorts map[string]sortKey[T], o ListOptions, scan func(*sql.Rows) (T, error)) (Page[T], error) {
+	var page Page[T]
+	where := ""
+	if len(q.where) > 0 {
+		where = " WHERE " + strings.Join(q.where, " AND ")
+	}
+	err := c.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+q.from+where, q.args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	key := sorts[o.Sort]
+	direction, cmp := "ASC", ">"
+	if o.Desc {
+		direction, cmp = "DESC", "<"
+	}
+	if o.After != nil {
+		if key.column == q.idColumn {
+			q.filter(q.idColumn+" "+cmp+" ?", o.After.ID)
+		} else {
+			q.filter("("+key.column+" "+cmp+" ? OR ("+key.column+" = ? AND "+q.idColumn+" "+cmp+" ?))", o.After.Value, o.After.Value, o.After.ID)
+		}
+	}
+	query := "SELECT " + q.columns + " FROM " + q.from
+	if len(q.where) > 0 {
+		query += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	query += " ORDER BY " + key.column + " " + direction
+	if key.column != q.idColumn {
+		query += ", " + q.idColumn + " " + direction
+	}
+	args := q.args
+	if o.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, o.Limit+1)
+	}
+
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	if o.Limit > 0 && len(page.Items) > o.Limit {
+		page.Items = page.Items[:o.Limit]
+		page.More = true
+	}
+	return page, rows.Err()
+}
+
+// CLIENTS
+const clientColumns = "id_client, firstname, lastname, email, role, COALESCE(id_salon, 0), COALESCE(id_coiffeur, 0), version"
+
+func scanClient(row interface{ Scan(...any) error }, client *Client) error {
+	return row.Scan(&client.ID_client, &client.Firstname, &client.Lastname, &client.Email, &client.Role, &client.ID_salon, &client.ID_coiffeur, &client.Version)
+}
+
+func (s *sqlStore) ListClients(ctx context.Context, f ClientFilter, o ListOptions) (Page[Client], error) {
+	q := listQuery{columns: clientColumns, from: "clients", idColumn: "id_client"}
+	if f.Role != "" {
+		q.filter("role=?", f.Role)
+	}
+	if f.Email != "" {
+		q.filter("email=?", f.Email)
+	}
+	return queryPage(ctx, s.conn(), q, clientSorts, o, func(rows *sql.Rows) (Client, error) {
+		var client Client
+		err := scanClient(rows, &client)
+		return client, err
+	})
+}
+
+func (s *sqlStore) GetClient(ctx context.Context, id int) (Client, error) {
+	var client Client
+	err := scanClient(s.conn().QueryRowContext(ctx, "SELECT "+clientColumns+" FROM clients WHERE id_client=?", id), &client)
+	return client, notFound(err)
+}
+
+func (s *sqlStore) GetClientByEmail(ctx context.Context, email string) (Client, error) {
+	var client Client
+	row := s.conn().QueryRowContext(ctx, "SELECT "+clientColumns+", password FROM clients WHERE email=?", email)
+	err := row.Scan(&client.ID_client, &client.Firstname, &client.Lastname, &client.Email, &client.Role, &client.ID_salon, &client.ID_coiffeur, &client.Version, &client.Password)
+	return client, notFound(err)
+}
+
+func (s *sqlStore) AddClient(ctx context.Context, client *Client) error {
+	id, err := s.conn().insert(ctx, "id_client", "INSERT INTO clients (firstname, lastname, email, password) VALUES (?, ?, ?, ?)", client.Firstname, client.Lastname, client.Email, client.Password)
+	if err != nil {
+		return err
+	}
+	client.ID_client = id
+	client.Role = RoleClient
+	client.ID_salon = 0
+	client.ID_coiffeur = 0
+	client.Version = 1
+	return nil
+}
+
+func (s *sqlStore) UpdateClient(ctx context.Context, client *Client) error {
+	var version int
+	var err error
+	if client.Password == "" {
+		version, err = s.updateVersioned(ctx, "clients", "id_client", client.ID_client, client.Version, "UPDATE clients SET firstname=?, lastname=?, email=?, version=version+1 WHERE id_client=?", client.Firstname, client.Lastname, client.Email, client.ID_client)
+	} else {
+		version, err = s.updateVersioned(ctx, "clients", "id_client", client.ID_client, client.Version, "UPDATE clients SET firstname=?, lastname=?, email=?, password=?, version=version+1 WHERE id_client=?", client.Firstname, client.Lastname, client.Email, client.Password, client.ID_client)
+	}
+	if err != nil {
+		return err
+	}
+	client.Version = version
+	return nil
+}
+
+func (s *sqlStore) SetClientPassword(ctx context.Context, id int, hash string) error {
+	return s.execOne(ctx, "SELECT id_client FROM clients WHERE id_client=?", "UPDATE clients SET password=? WHERE id_client=?", hash, id)
+}
+
+func (s *sqlStore) SetClientRole(ctx context.Context, id int, role string, idSalon, idCoiffeur int) error {
+	salon := sql.NullInt64{Int64: int64(idSalon), Valid: idSalon != 0}
+	coiffeur := sql.NullInt64{Int64: int64(idCoiffeur), Valid: idCoiffeur != 0}
+	return s.execOne(ctx, "SELECT id_client FROM clients WHERE id_client=?", "UPDATE clients SET role=?, id_salon=?, id_coiffeur=?, version=version+1 WHERE id_client=?", role, salon, coiffeur, id)
+}
+
+func (s *sqlStore) DeleteClient(ctx context.Context, id, version int) error {
+	return s.deleteVersioned(ctx, "clients", "id_client", id, version)
+}
+
+// SALONS
+func (s *sqlStore) ListSalons(ctx context.Context, f SalonFilter, o ListOptions) (Page[Salon], error) {
+	q := listQuery{columns: "id_salon, name, timezone, version", from: "salons", idColumn: "id_salon"}
+	if f.Name != "" {
+		q.filter("LOWER(name) LIKE ?", "%"+strings.ToLower(f.Name)+"%")
+	}
+	return queryPage(ctx, s.conn(), q, salonSorts, o, func(rows *sql.Rows) (Salon, error) {
+		var salon Salon
+		err := rows.Scan(&salon.ID_salon, &salon.Name, &salon.Timezone, &salon.Version)
+		return salon, err
+	})
+}
+
+func (s *sqlStore) GetSalon(ctx context.Context, id int) (Salon, error) {
+	var salon Salon
+	err := s.conn().QueryRowContext(ctx, "SELECT id_salon, name, timezone, version FROM salons WHERE id_salon=?", id).Scan(&salon.ID_salon, &salon.Name, &salon.Timezone, &salon.Version)
+	return salon, notFound(err)
+}
+
+func (s *sqlStore) AddSalon(ctx context.Context, salon *Salon) error {
+	id, err := s.conn().insert(ctx, "id_salon", "INSERT INTO salons (name, timezone) VALUES (?, ?)", salon.Name, salon.Timezone)
+	salon.ID_salon = id
+	salon.Version = 1
+	return err
+}
+
+func (s *sqlStore) UpdateSalon(ctx context.Context, salon *Salon) error {
+	version, err := s.updateVersioned(ctx, "salons", "id_salon", salon.ID_salon, salon.Version, "UPDATE salons SET name=?, timezone=?, version=version+1 WHERE id_salon=?", salon.Name, salon.Timezone, salon.ID_salon)
+	if err != nil {
+		return err
+	}
+	salon.Version = version
+	return nil
+}
+
+func (s *sqlStore) DeleteSalon(ctx context.Context, id, version int) error {
+	return s.deleteVersioned(ctx, "salons", "id_salon", id, version)
+}
+
+// COIFFEURS
+// coiffeurServices returns the services of the given coiffeurs.
+func (s *sqlStore) coiffeurServices(ctx context.Context, coiffeurs []int) (map[int][]int, error) {
+	services := map[int][]int{}
+	if len(coiffeurs) == 0 {
+		return services, nil
+	}
+	args := make([]any, len(coiffeurs))
+	for i, id := range coiffeurs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(coiffeurs)), ", ")
+
+	rows, err := s.conn().QueryContext(ctx, "SELECT id_coiffeur, id_service FROM coiffeur_services WHERE id_coiffeur IN ("+placeholders+") ORDER BY id_coiffeur, id_service", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idCoiffeur, idService int
+		if err := rows.Scan(&idCoiffeur, &idService); err != nil {
+			return nil, err
+		}
+		services[idCoiffeur] = append(services[idCoiffeur], idService)
+	}
+	return services, rows.Err()
+}
+
+// setCoiffeurServices replaces the services of a coiffeur inside a transaction.
+func (s *sqlStore) setCoiffeurServices(ctx context.Context, c sqlConn, idCoiffeur int, services []int) error {
+	_, err := c.ExecContext(ctx, "DELETE FROM coiffeur_services WHERE id_coiffeur=?", idCoiffeur)
+	if err != nil {
+		return err
+	}
+	for _, idService := range uniqueServices(services) {
+		_, err = c.ExecContext(ctx, "INSERT INTO coiffeur_services (id_coiffeur, id_service) VALUES (?, ?)", idCoiffeur, idService)
+		if err != nil {
+			return notFound(err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) ListCoiffeurs(ctx context.Context, f CoiffeurFilter, o ListOptions) (Page[Coiffeur], error) {
+	q := listQuery{columns: "id_coiffeur, id_salon, firstname, lastname, version", from: "coiffeurs", idColumn: "id_coiffeur"}
+	if f.ID_salon != 0 {
+		q.filter("id_salon=?", f.ID_salon)
+	}
+	if f.ID_service != 0 {
+		q.filter("id_coiffeur IN (SELECT id_coiffeur FROM coiffeur_services WHERE id_service=?)", f.ID_service)
+	}
+	page, err := queryPage(ctx, s.conn(), q, coiffeurSorts, o, func(rows *sql.Rows) (Coiffeur, error) {
+		var coiffeur Coiffeur
+		err := rows.Scan(&coiffeur.ID_coiffeur, &coiffeur.ID_salon, &coiffeur.Firstname, &coiffeur.Lastname, &coiffeur.Version)
+		return coiffeur, err
+	})
+	if err != nil {
+		return page, err
+	}
+
+	ids := make([]int, len(page.Items))
+	for i, coiffeur := range page.Items {
+		ids[i] = coiffeur.ID_coiffeur
+	}
+	services, err := s.coiffeurServices(ctx, ids)
+	if err != nil {
+		return page, err
+	}
+	for i := range page.Items {
+		page.Items[i].Services = services[page.Items[i].ID_coiffeur]
+	}
+	return page, nil
+}
+
+func (s *sqlStore) GetCoiffeur(ctx context.Context, id int) (Coiffeur, error) {
+	var coiffeur Coiffeur
+	row := s.conn().QueryRowContext(ctx, "SELECT id_coiffeur, id_salon, firstname, lastname, version FROM coiffeurs WHERE id_coiffeur=?", id)
+	if err := row.Scan(&coiffeur.ID_coiffeur, &coiffeur.ID_salon, &coiffeur.Firstname, &coiffeur.Lastname, &coiffeur.Version); err != nil {
+		return coiffeur, notFound(err)
+	}
+
+	rows, err := s.conn().QueryContext(ctx, "SELECT id_service FROM coiffeur_services WHERE id_coiffeur=? ORDER BY id_service", id)
+	if err != nil {
+		return coiffeur, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idService int
+		if err := rows.Scan(&idService); err != nil {
+			return coiffeur, err
+		}
+		coiffeur.Services = append(coiffeur.Services, idService)
+	}
+	return coiffeur, rows.Err()
+}
+
+func (s *sqlStore) AddCoiffeur(ctx context.Context, coiffeur *Coiffeur) error {
+	tx, c, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := c.insert(ctx, "id_coiffeur", "INSERT INTO coiffeurs (id_salon, firstname, lastname) VALUES (?, ?, ?)", coiffeur.ID_salon, coiffeur.Firstname, coiffeur.Lastname)
+	if err != nil {
+		return err
+	}
+	if err := s.setCoiffeurServices(ctx, c, id, coiffeur.Services); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	coiffeur.ID_coiffeur = id
+	coiffeur.Version = 1
+	return nil
+}
+
+func (s *sqlStore) UpdateCoiffeur(ctx context.Context, coiffeur *Coiffeur) error {
+	current, err := s.versioned(ctx, "coiffeurs", "id_coiffeur", coiffeur.ID_coiffeur, coiffeur.Version, func(c sqlConn) error {
+		_, err := c.ExecContext(ctx, "UPDATE coiffeurs SET id_salon=?, firstname=?, lastname=?, version=version+1 WHERE id_coiffeur=?", coiffeur.ID_salon, coiffeur.Firstname, coiffeur.Lastname, coiffeur.ID_coiffeur)
+		if err != nil || coiffeur.Services == nil {
+			return err
+		}
+		return s.setCoiffeurServices(ctx, c, coiffeur.ID_coiffeur, coiffeur.Services)
+	})
+	if err != nil {
+		return err
+	}
+	coiffeur.Version = current + 1
+	return nil
+}
+
+func (s *sqlStore) DeleteCoiffeur(ctx context.Context, id, version int) error {
+	_, err := s.versioned(ctx, "coiffeurs", "id_coiffeur", id, version, func(c sqlConn) error {
+		if _, err := c.ExecContext(ctx, "DELETE FROM coiffeur_services WHERE id_coiffeur=?", id); err != nil {
+			return err
+		}
+		_, err := c.ExecContext(ctx, "DELETE FROM coiffeurs WHERE id_coiffeur=?", id)
+		return err
+	})
+	return err
+}
+
+// SERVICES
+func (s *sqlStore) ListServices(ctx context.Context, idSalon int) ([]Service, error) {
+	query := "SELECT id_service, id_salon, name, duration_minutes, price, category, version FROM services"
+	var args []any
+	if idSalon != 0 {
+		query += " WHERE id_salon=?"
+		args = append(args, idSalon)
+	}
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var serviceList []Service
+	for rows.Next() {
+		var service Service
+		if err := rows.Scan(&service.ID_service, &service.ID_salon, &service.Name, &service.Duration, &service.Price, &service.Category, &service.Version); err != nil {
+			return nil, err
+		}
+		serviceList = append(serviceList, service)
+	}
+	return serviceList, rows.Err()
+}
+
+func (s *sqlStore) GetService(ctx context.Context, id int) (Service, error) {
+	var service Service
+	row := s.conn().QueryRowContext(ctx, "SELECT id_service, id_salon, name, duration_minutes, price, category, version FROM services WHERE id_service=?", id)
+	err := row.Scan(&service.ID_service, &service.ID_salon, &service.Name, &service.Duration, &service.Price, &service.Category, &service.Version)
+	return service, notFound(err)
+}
+
+func (s *sqlStore) AddService(ctx context.Context, service *Service) error {
+	id, err := s.conn().insert(ctx, "id_service", "INSERT INTO services (id_salon, name, duration_minutes, price, category) VALUES (?, ?, ?, ?, ?)", service.ID_salon, service.Name, service.Duration, service.Price, service.Category)
+	if err != nil {
+		return err
+	}
+	service.ID_service = id
+	service.Version = 1
+	return nil
+}
+
+func (s *sqlStore) UpdateService(ctx context.Context, service *Service) error {
+	version, err := s.updateVersioned(ctx, "services", "id_service", service.ID_service, service.Version, "UPDATE services SET id_salon=?, name=?, duration_minutes=?, price=?, category=?, version=version+1 WHERE id_service=?", service.ID_salon, service.Name, service.Duration, service.Price, service.Category, service.ID_service)
+	if err != nil {
+		return err
+	}
+	service.Version = version
+	return nil
+}
+
+// DeleteService unlinks the service from its coiffeurs in the same
+// transaction, so that the links stay when a booking still references it.
+func (s *sqlStore) DeleteService(ctx context.Context, id, version int) error {
+	_, err := s.versioned(ctx, "services", "id_service", id, version, func(c sqlConn) error {
+		if _, err := c.ExecContext(ctx, "DELETE FROM coiffeur_services WHERE id_service=?", id); err != nil {
+			return err
+		}
+		_, err := c.ExecContext(ctx, "DELETE FROM services WHERE id_service=?", id)
+		return err
+	})
+	return err
+}
+
+// HORAIRES
+func (s *sqlStore) ListHoraires(ctx context.Context, idCoiffeur int) ([]Horaire, error) {
+	query := "SELECT id_horaire, id_coiffeur, weekday, start_time, end_time, version FROM horaires"
+	var args []any
+	if idCoiffeur != 0 {
+		query += " WHERE id_coiffeur=?"
+		args = append(args, idCoiffeur)
+	}
+	query += " ORDER BY id_coiffeur, weekday, start_time"
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var horaireList []Horaire
+	for rows.Next() {
+		var horaire Horaire
+		if err := rows.Scan(&horaire.ID_horaire, &horaire.ID_coiffeur, &horaire.Weekday, &horaire.Start, &horaire.End, &horaire.Version); err != nil {
+			return nil, err
+		}
+		horaireList = append(horaireList, horaire)
+	}
+	return horaireList, rows.Err()
+}
+
+func (s *sqlStore) GetHoraire(ctx context.Context, id int) (Horaire, error) {
+	var horaire Horaire
+	row := s.conn().QueryRowContext(ctx, "SELECT id_horaire, id_coiffeur, weekday, start_time, end_time, version FROM horaires WHERE id_horaire=?", id)
+	err := row.Scan(&horaire.ID_horaire, &horaire.ID_coiffeur, &horaire.Weekday, &horaire.Start, &horaire.End, &horaire.Version)
+	return horaire, notFound(err)
+}
+
+func (s *sqlStore) AddHoraire(ctx context.Context, horaire *Horaire) error {
+	id, err := s.conn().insert(ctx, "id_horaire", "INSERT INTO horaires (id_coiffeur, weekday, start_time, end_time) VALUES (?, ?, ?, ?)", horaire.ID_coiffeur, horaire.Weekday, horaire.Start, horaire.End)
+	if err != nil {
+		return err
+	}
+	horaire.ID_horaire = id
+	horaire.Version = 1
+	return nil
+}
+
+func (s *sqlStore) UpdateHoraire(ctx context.Context, horaire *Horaire) error {
+	version, err := s.updateVersioned(ctx, "horaires", "id_horaire", horaire.ID_horaire, horaire.Version, "UPDATE horaires SET id_coiffeur=?, weekday=?, start_time=?, end_time=?, version=version+1 WHERE id_horaire=?", horaire.ID_coiffeur, horaire.Weekday, horaire.Start, horaire.End, horaire.ID_horaire)
+	if err != nil {
+		return err
+	}
+	horaire.Version = version
+	return nil
+}
+
+func (s *sqlStore) DeleteHoraire(ctx context.Context, id, version int) error {
+	return s.deleteVersioned(ctx, "horaires", "id_horaire", id, version)
+}
+
+// CRENEAUX
+// Creneaux whose legacy text date could not be converted have no start_at
+// and are left out until fixed.
+const creneauQuery = "SELECT id_creneau, id_coiffeur, start_at, end_at, availability, version FROM creneaux WHERE start_at IS NOT NULL"
+
+func (s *sqlStore) queryCreneaux(ctx context.Context, where string, args ...any) ([]Creneau, error) {
+	return scanCreneaux(s.conn().QueryContext(ctx, creneauQuery+where+" ORDER BY start_at", args...))
+}
+
+// lockCreneaux is queryCreneaux within a transaction, locking the rows it
+// returns until the transaction ends.
+func (s *sqlStore) lockCreneaux(ctx context.Context, c sqlConn, where string, args ...any) ([]Creneau, error) {
+	return scanCreneaux(c.QueryContext(ctx, creneauQuery+where+" ORDER BY start_at"+s.d.forUpdate, args...))
+}
+
+func scanCreneaux(rows *sql.Rows, err error) ([]Creneau, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creneauList []Creneau
+	for rows.Next() {
+		var creneau Creneau
+		if err := rows.Scan(&creneau.ID_creneau, &creneau.ID_coiffeur, &creneau.Start, &creneau.End, &creneau.Availability, &creneau.Version); err != nil {
+			return nil, err
+		}
+		creneauList = append(creneauList, creneau)
+	}
+	return creneauList, rows.Err()
+}
+
+func (s *sqlStore) ListCreneaux(ctx context.Context, f CreneauFilter, o ListOptions) (Page[Creneau], error) {
+	q := listQuery{columns: "id_creneau, id_coiffeur, start_at, end_at, availability, version", from: "creneaux", idColumn: "id_creneau"}
+	q.filter("start_at IS NOT NULL")
+	if f.ID_coiffeur != 0 {
+		q.filter("id_coiffeur=?", f.ID_coiffeur)
+	}
+	if !f.From.IsZero() {
+		q.filter("start_at >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		q.filter("start_at < ?", f.To.UTC())
+	}
+	if f.Available != nil {
+		q.filter("availability=?", *f.Available)
+	}
+	return queryPage(ctx, s.conn(), q, creneauSorts, o, func(rows *sql.Rows) (Creneau, error) {
+		var creneau Creneau
+		err := rows.Scan(&creneau.ID_creneau, &creneau.ID_coiffeur, &creneau.Start, &creneau.End, &creneau.Availability, &creneau.Version)
+		return creneau, err
+	})
+}
+
+func (s *sqlStore) GetCreneau(ctx context.Context, id int) (Creneau, error) {
+	creneaux, err := s.queryCreneaux(ctx, " AND id_creneau=?", id)
+	if err != nil {
+		return Creneau{}, err
+	}
+	if len(creneaux) == 0 {
+		return Creneau{}, errNotFound
+	}
+	return creneaux[0], nil
+}
+
+func (s *sqlStore) AddCreneau(ctx context.Context, creneau *Creneau) error {
+	id, err := s.conn().insert(ctx, "id_creneau", "INSERT INTO creneaux (id_coiffeur, start_at, end_at, availability) VALUES (?, ?, ?, ?)", creneau.ID_coiffeur, creneau.Start.UTC(), creneau.End.UTC(), creneau.Availability)
+	creneau.ID_creneau = id
+	creneau.Version = 1
+	return err
+}
+
+func (s *sqlStore) UpdateCreneau(ctx context.Context, creneau *Creneau) error {
+	current, err := s.versioned(ctx, "creneaux", "id_creneau", creneau.ID_creneau, creneau.Version, func(c sqlConn) error {
+		if err := s.checkNotHeld(ctx, c, creneau.ID_creneau); err != nil {
+			return err
+		}
+		_, err := c.ExecContext(ctx, "UPDATE creneaux SET id_coiffeur=?, start_at=?, end_at=?, availability=?, version=version+1 WHERE id_creneau=?", creneau.ID_coiffeur, creneau.Start.UTC(), creneau.End.UTC(), creneau.Availability, creneau.ID_creneau)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	creneau.Version = current + 1
+	return nil
+}
+
+func (s *sqlStore) DeleteCreneau(ctx context.Context, id, version int) error {
+	_, err := s.versioned(ctx, "creneaux", "id_creneau", id, version, func(c sqlConn) error {
+		if err := s.checkNotHeld(ctx, c, id); err != nil {
+			return err
+		}
+		_, err := c.ExecContext(ctx, "DELETE FROM creneaux WHERE id_creneau=?", id)
+		return err
+	})
+	return err
+}
+
+// checkNotHeld fails with errReferenced when a reservation took the creneau
+// id. The foreign key only covers the first creneau of a booking, so the
+// ones its span goes on with are checked here. Spans of a coiffeur do not
+// overlap: only the reservation starting last, no later than the creneau,
+// can hold it.
+func (s *sqlStore) checkNotHeld(ctx context.Context, c sqlConn, id int) error {
+	creneaux, err := s.lockCreneaux(ctx, c, " AND id_creneau=?", id)
+	if err != nil || len(creneaux) == 0 {
+		return err
+	}
+	creneau := creneaux[0]
+
+	var first Creneau
+	var duration int
+	row := c.QueryRowContext(ctx, `
+		SELECT f.id_creneau, f.id_coiffeur, f.start_at, COALESCE(r.duration_minutes, 0)
+		FROM reservations r
+		JOIN creneaux f ON f.id_creneau = r.id_creneau
+		WHERE f.id_coiffeur=? AND f.start_at <= ?
+		ORDER BY f.start_at DESC
+		LIMIT 1`, creneau.ID_coiffeur, creneau.Start.UTC())
+	err = row.Scan(&first.ID_creneau, &first.ID_coiffeur, &first.Start, &duration)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if spanHolds(first, time.Duration(duration)*time.Minute, creneau) {
+		return errReferenced
+	}
+	return nil
+}
+
+func (s *sqlStore) FreeCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error) {
+	return s.queryCreneaux(ctx, " AND id_coiffeur=? AND availability=true AND start_at >= ? AND start_at < ?", idCoiffeur, from.UTC(), to.UTC())
+}
+
+func (s *sqlStore) CoiffeurCreneaux(ctx context.Context, idCoiffeur int, from, to time.Time) ([]Creneau, error) {
+	return s.queryCreneaux(ctx, " AND id_coiffeur=? AND start_at < ? AND end_at > ?", idCoiffeur, to.UTC(), from.UTC())
+}
+
+// RESERVATIONS
+const reservationColumns = "r.id_reservation, COALESCE(r.id_client, 0), r.id_salon, r.id_coiffeur, r.id_creneau, COALESCE(r.id_service, 0), COALESCE(r.duration_minutes, 0), COALESCE(r.price, 0), r.version"
+
+func (s *sqlStore) queryReservations(ctx context.Context, query string, args ...any) ([]Reservation, error) {
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservationList []Reservation
+	for rows.Next() {
+		var reservation Reservation
+		err := rows.Scan(&reservation.ID_reservation, &reservation.ID_client, &reservation.ID_salon, &reservation.ID_coiffeur, &reservation.ID_creneau, &reservation.ID_service, &reservation.Duration, &reservation.Price, &reservation.Version)
+		if err != nil {
+			return nil, err
+		}
+		reservationList = append(reservationList, reservation)
+	}
+	return reservationList, rows.Err()
+}
+
+func (s *sqlStore) ListReservations(ctx context.Context, f ReservationFilter, o ListOptions) (Page[Reservation], error) {
+	q := listQuery{columns: reservationColumns, from: "reservations r", idColumn: "r.id_reservation"}
+	if f.ID_client != 0 {
+		q.filter("r.id_client=?", f.ID_client)
+	}
+	if f.ID_salon != 0 {
+		q.filter("r.id_salon=?", f.ID_salon)
+	}
+	if f.ID_coiffeur != 0 {
+		q.filter("r.id_coiffeur=?", f.ID_coiffeur)
+	}
+	switch f.Status {
+	case statusUpcoming:
+		q.filter("r.id_creneau IN (SELECT id_creneau FROM creneaux WHERE start_at >= ?)", f.Now.UTC())
+	case statusPast:
+		q.filter("r.id_creneau IN (SELECT id_creneau FROM creneaux WHERE start_at < ?)", f.Now.UTC())
+	}
+	return queryPage(ctx, s.conn(), q, reservationSorts, o, func(rows *sql.Rows) (Reservation, error) {
+		var reservation Reservation
+		err := rows.Scan(&reservation.ID_reservation, &reservation.ID_client, &reservation.ID_salon, &reservation.ID_coiffeur, &reservation.ID_creneau, &reservation.ID_service, &reservation.Duration, &reservation.Price, &reservation.Version)
+		return reservation, err
+	})
+}
+
+func (s *sqlStore) GetReservation(ctx context.Context, id int) (Reservation, error) {
+	reservations, err := s.queryReservations(ctx, "SELECT "+reservationColumns+" FROM reservations r WHERE r.id_reservation=?", id)
+	if err != nil {
+		return Reservation{}, err
+	}
+	if len(reservations) == 0 {
+		return Reservation{}, errNotFound
+	}
+	return reservations[0], nil
+}
+
+// BookReservation locks the creneaux the booking takes with SELECT ... FOR
+// UPDATE so that concurrent bookings of overlapping spans are serialized: the
+// first one commits, the others see availability=false. SQLite has no row
+// locks; its single connection serializes the transactions instead.
+func (s *sqlStore) BookReservation(ctx context.Context, reservation Reservation) (Reservation, error) {
+	tx, c, err := s.begin(ctx)
+	if err != nil {
+		return reservation, err
+	}
+	defer tx.Rollback()
+
+	var idClient int
+	row := c.QueryRowContext(ctx, "SELECT id_client FROM clients WHERE id_client=?", reservation.ID_client)
+	if err := row.Scan(&idClient); err != nil {
+		if err == sql.ErrNoRows {
+			return reservation, errClientNotFound
+		}
+		return reservation, err
+	}
+
+	var idSalon int
+	row = c.QueryRowContext(ctx, "SELECT id_salon, duration_minutes, price FROM services WHERE id_service=?", reservation.ID_service)
+	if err := row.Scan(&idSalon, &reservation.Duration, &reservation.Price); err != nil {
+		if err == sql.ErrNoRows {
+			return reservation, errServiceNotFound
+		}
+		return reservation, err
+	}
+	if idSalon != reservation.ID_salon {
+		return reservation, errServiceNotOffered
+	}
+
+	var performs int
+	row = c.QueryRowContext(ctx, "SELECT COUNT(*) FROM coiffeur_services WHERE id_coiffeur=? AND id_service=?", reservation.ID_coiffeur, reservation.ID_service)
+	if err := row.Scan(&performs); err != nil {
+		return reservation, err
+	}
+	if performs == 0 {
+		return reservation, errServiceNotOffered
+	}
+
+	span, err := s.lockSpan(ctx, c, reservation.ID_creneau, time.Duration(reservation.Duration)*time.Minute)
+	if err != nil {
+		return reservation, err
+	}
+	if err := checkSpan(span, reservation); err != nil {
+		return reservation, err
+	}
+
+	id, err := c.insert(ctx, "id_reservation", "INSERT INTO reservations (id_client, id_salon, id_coiffeur, id_creneau, id_service, duration_minutes, price) VALUES (?, ?, ?, ?, ?, ?, ?)", reservation.ID_client, reservation.ID_salon, reservation.ID_coiffeur, reservation.ID_creneau, reservation.ID_service, reservation.Duration, reservation.Price)
+	if err != nil {
+		return reservation, err
+	}
+	if err := setAvailability(ctx, c, span, false); err != nil {
+		return reservation, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return reservation, err
+	}
+
+	reservation.ID_reservation = id
+	reservation.Version = 1
+	return reservation, nil
+}
+
+// lockSpan locks the creneau idCreneau and those of the same coiffeur that
+// start within duration of it, and returns the span bookingSpan finds in
+// them.
+func (s *sqlStore) lockSpan(ctx context.Context, c sqlConn, idCreneau int, duration time.Duration) ([]Creneau, error) {
+	first, err := s.lockCreneaux(ctx, c, " AND id_creneau=?", idCreneau)
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 {
+		return nil, errCreneauNotFound
+	}
+	next, err := s.lockCreneaux(ctx, c, " AND id_coiffeur=? AND start_at > ? AND start_at < ?", first[0].ID_coiffeur, first[0].Start.UTC(), first[0].Start.Add(duration).UTC())
+	if err != nil {
+		return nil, err
+	}
+	span, _ := bookingSpan(append(first, next...), duration)
+	return span, nil
+}
+
+func setAvailability(ctx context.Context, c sqlConn, span []Creneau, availability bool) error {
+	for _, creneau := range span {
+		_, err := c.ExecContext(ctx, "UPDATE creneaux SET availability=?, version=version+1 WHERE id_creneau=?", availability, creneau.ID_creneau)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateReservation moves a reservation given another creneau or coiffeur
+// in the transaction that updates it: the creneaux it took are freed and the
+// new ones checked and taken as BookReservation does.
+func (s *sqlStore) UpdateReservation(ctx context.Context, reservation *Reservation) error {
+	current, err := s.versioned(ctx, "reservations", "id_reservation", reservation.ID_reservation, reservation.Version, func(c sqlConn) error {
+		var moved Reservation
+		row := c.QueryRowContext(ctx, "SELECT id_coiffeur, id_creneau, COALESCE(id_service, 0), COALESCE(duration_minutes, 0) FROM reservations WHERE id_reservation=?", reservation.ID_reservation)
+		if err := row.Scan(&moved.ID_coiffeur, &moved.ID_creneau, &moved.ID_service, &moved.Duration); err != nil {
+			return err
+		}
+		if moved.ID_service != reservation.ID_service {
+			return errServiceChanged
+		}
+
+		var idSalon int
+		row = c.QueryRowContext(ctx, "SELECT id_salon FROM coiffeurs WHERE id_coiffeur=?", reservation.ID_coiffeur)
+		if err := row.Scan(&idSalon); err != nil {
+			if err == sql.ErrNoRows {
+				return errMissingReference
+			}
+			return err
+		}
+		if idSalon != reservation.ID_salon {
+			return errCoiffeurOtherSalon
+		}
+
+		if moved.ID_creneau != reservation.ID_creneau || moved.ID_coiffeur != reservation.ID_coiffeur {
+			if err := s.moveReservation(ctx, c, moved, reservation.ID_coiffeur, reservation.ID_creneau); err != nil {
+				return err
+			}
+		}
+		_, err := c.ExecContext(ctx, "UPDATE reservations SET id_client=?, id_salon=?, id_coiffeur=?, id_creneau=?, version=version+1 WHERE id_reservation=?", reservation.ID_client, reservation.ID_salon, reservation.ID_coiffeur, reservation.ID_creneau, reservation.ID_reservation)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	reservation.Version = current + 1
+	return nil
+}
+
+// moveReservation frees the creneaux taken by reservation and takes those of
+// the coiffeur idCoiffeur from idCreneau on. Freeing first lets a
+// reservation move onto creneaux it already holds.
+func (s *sqlStore) moveReservation(ctx context.Context, c sqlConn, reservation Reservation, idCoiffeur, idCreneau int) error {
+	duration := time.Duration(reservation.Duration) * time.Minute
+	span, err := s.lockSpan(ctx, c, reservation.ID_creneau, duration)
+	if err != nil && err != errCreneauNotFound {
+		return err
+	}
+	if err := setAvailability(ctx, c, span, true); err != nil {
+		return err
+	}
+
+	if reservation.ID_service != 0 && idCoiffeur != reservation.ID_coiffeur {
+		var performs int
+		row := c.QueryRowContext(ctx, "SELECT COUNT(*) FROM coiffeur_services WHERE id_coiffeur=? AND id_service=?", idCoiffeur, reservation.ID_service)
+		if err := row.Scan(&performs); err != nil {
+			return err
+		}
+		if performs == 0 {
+			return errServiceNotOffered
+		}
+	}
+
+	reservation.ID_coiffeur = idCoiffeur
+	span, err = s.lockSpan(ctx, c, idCreneau, duration)
+	if err != nil {
+		return err
+	}
+	if err := checkSpan(span, reservation); err != nil {
+		return err
+	}
+	return setAvailability(ctx, c, span, false)
+}
+
+// DeleteReservation frees the creneaux the reservation took in the
+// transaction that deletes it.
+func (s *sqlStore) DeleteReservation(ctx context.Context, id, version int) error {
+	_, err := s.versioned(ctx, "reservations", "id_reservation", id, version, func(c sqlConn) error {
+		var idCreneau, duration int
+		row := c.QueryRowContext(ctx, "SELECT id_creneau, COALESCE(duration_minutes, 0) FROM reservations WHERE id_reservation=?", id)
+		if err := row.Scan(&idCreneau, &duration); err != nil {
+			return err
+		}
+		span, err := s.lockSpan(ctx, c, idCreneau, time.Duration(duration)*time.Minute)
+		if err != nil && err != errCreneauNotFound {
+			return err
+		}
+		if _, err := c.ExecContext(ctx, "DELETE FROM reservations WHERE id_reservation=?", id); err != nil {
+			return err
+		}
+		return setAvailability(ctx, c, span, true)
+	})
+	return err
+}
+
+func (s *sqlStore) ListClientReservations(ctx context.Context, idClient int, now time.Time, upcoming bool) ([]Reservation, error) {
+	op := "<"
+	if upcoming {
+		op = ">="
+	}
+	return s.queryReservations(ctx, `
+		SELECT `+reservationColumns+`
+		FROM reservations r
+		JOIN creneaux c ON c.id_creneau = r.id_creneau
+		WHERE r.id_client=? AND c.start_at `+op+` ?
+		ORDER BY c.start_at`, idClient, now.UTC())
+}
+
+// SESSIONS
+func (s *sqlStore) AddSession(ctx context.Context, idClient int, tokenHash string, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO sessions (id_client, token_hash, expires_at) VALUES (?, ?, ?)", idClient, tokenHash, expiresAt.UTC())
+	return notFound(err)
+}
+
+func (s *sqlStore) GetSessionPrincipal(ctx context.Context, tokenHash string, now time.Time) (Principal, error) {
+	var principal Principal
+	row := s.conn().QueryRowContext(ctx, `
+		SELECT c.id_client, c.role, COALESCE(c.id_salon, 0), COALESCE(c.id_coiffeur, 0)
+		FROM sessions s
+		JOIN clients c ON c.id_client = s.id_client
+		WHERE s.token_hash=? AND s.revoked_at IS NULL AND s.expires_at > ?`, tokenHash, now.UTC())
+	if err := row.Scan(&principal.ID_client, &principal.Role, &principal.ID_salon, &principal.ID_coiffeur); err != nil {
+		if err == sql.ErrNoRows {
+			return Principal{}, errInvalidSession
+		}
+		return Principal{}, err
+	}
+	return principal, nil
+}
+
+func (s *sqlStore) RevokeSession(ctx context.Context, tokenHash string, now time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE sessions SET revoked_at=? WHERE token_hash=? AND revoked_at IS NULL", now.UTC(), tokenHash)
+	return err
+}
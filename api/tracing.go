@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "salon-api"
+
+var tracingExporters = []string{"none", "otlp", "stdout"}
+
+// tracer goes through the global provider, so spans are dropped until
+// setupTracing installs an exporter.
+var tracer = otel.Tracer("main")
+
+// setupTracing installs the W3C trace context propagator and, unless the
+// exporter is none, a provider sending spans to an OTLP collector over HTTP
+// or printing them on stdout. The returned function flushes pending spans.
+func setupTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a span for every request, continuing the trace of an
+// incoming traceparent header. Spans are named after the matched route;
+// probes and scrapes are left out.
+func traceRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, route := mux.Handler(r)
+			if route == "" || route == "/" {
+				return r.Method
+			}
+			return r.Method + " " + route
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/healthz", "/readyz", "/metrics":
+				return false
+			}
+			return true
+		}),
+	)
+}
+
+// startQuerySpan starts the span of one SQL statement, named after its
+// operation, e.g. SELECT.
+func startQuerySpan(ctx context.Context, system, query string) (context.Context, trace.Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.statement", statement),
+			attribute.String("db.operation", operation),
+		),
+	)
+}
+
+// endQuerySpan records err, if any, and ends span.
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
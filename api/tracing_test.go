@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// The global tracer binds to the first provider installed, so this is the
+// only test that installs one.
+func TestTracing(t *testing.T) {
+	if _, err := setupTracing(context.Background(), TracingConfig{Exporter: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	api := newTestAPI(t, openTestDB(t, "sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")))
+	api.do("GET", "/api/salons", api.admin, nil, "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").
+		expect(t, http.StatusOK)
+	api.do("GET", "/healthz", "", nil).expect(t, http.StatusOK)
+
+	// The server ends its span after the response is written.
+	var request sdktrace.ReadOnlySpan
+	for deadline := time.Now().Add(time.Second); request == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, span := range recorder.Ended() {
+			if span.Name() == "GET /api/salons" {
+				request = span
+			}
+		}
+	}
+	if request == nil {
+		t.Fatal("no span for GET /api/salons")
+	}
+	if got := request.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace %s, want the one of the traceparent header", got)
+	}
+	if got := request.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span %s, want the one of the traceparent header", got)
+	}
+
+	queries := 0
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == request.SpanContext().SpanID() && span.Name() == "SELECT" {
+			queries++
+		}
+		if span.Name() == "GET /healthz" {
+			t.Error("probe traced")
+		}
+	}
+	if queries == 0 {
+		t.Error("no SELECT span under the request")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Payloads are validated from `validate` struct tags before anything reaches
+// the stores. A tag lists rules separated by commas:
+//
+//	required            the field is not its zero value
+//	required_on_create  same, but only when creating
+//	email               a bare address such as ana@example.com
+//	min=N, max=N        bounds on a number, or on the length of a string
+//	timezone            an IANA time zone name
+//	time_of_day         a time of day such as 09:00
+//	after=Field         a time, or time of day, later than the named field's
+//	ref=kind            the id of an existing salon, coiffeur, client,
+//	                    creneau or service
+//
+// A field reports its first broken rule only; every field is checked, so one
+// response lists all the problems. Zero values skip every rule but the
+// required ones.
+
+type operation int
+
+const (
+	onCreate operation = iota
+	onUpdate
+)
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []rule
+}
+
+type rule struct {
+	name, arg string
+}
+
+var rulesCache sync.Map // reflect.Type -> []fieldRules
+
+func rulesOf(t reflect.Type) []fieldRules {
+	if cached, ok := rulesCache.Load(t); ok {
+		return cached.([]fieldRules)
+	}
+
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		field := fieldRules{index: i, name: name}
+		for _, item := range strings.Split(tag, ",") {
+			ruleName, arg, _ := strings.Cut(item, "=")
+			field.rules = append(field.rules, rule{ruleName, arg})
+		}
+		fields = append(fields, field)
+	}
+	rulesCache.Store(t, fields)
+	return fields
+}
+
+// validate checks v, a struct or a pointer to one, against its tags. Errors
+// other than field violations come from the stores while resolving
+// references.
+func (s *server) validate(ctx context.Context, v any, op operation) ([]FieldError, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var fieldErrors []FieldError
+
+	for _, field := range rulesOf(rv.Type()) {
+		value := rv.Field(field.index)
+		for _, rule := range field.rules {
+			fieldErr, err := s.checkRule(ctx, rv, value, rule, op)
+			if err != nil {
+				return nil, err
+			}
+			if fieldErr != nil {
+				fieldErr.Field = field.name
+				fieldErrors = append(fieldErrors, *fieldErr)
+				break
+			}
+		}
+	}
+	return fieldErrors, nil
+}
+
+func (s *server) checkRule(ctx context.Context, parent, value reflect.Value, r rule, op operation) (*FieldError, error) {
+	switch r.name {
+	case "required":
+		if value.IsZero() {
+			return &FieldError{Code: "required", Message: "is required"}, nil
+		}
+		return nil, nil
+	case "required_on_create":
+		if op == onCreate && value.IsZero() {
+			return &FieldError{Code: "required", Message: "is required"}, nil
+		}
+		return nil, nil
+	}
+	if value.IsZero() {
+		return nil, nil
+	}
+
+	switch r.name {
+	case "email":
+		addr, err := mail.ParseAddress(value.String())
+		if err != nil || addr.Address != value.String() {
+			return &FieldError{Code: "invalid_email", Message: "must be an email address"}, nil
+		}
+	case "min", "max":
+		return checkBound(value, r), nil
+	case "timezone":
+		if !validTimezone(value.String()) {
+			return &FieldError{Code: "invalid", Message: "must be an IANA time zone such as Europe/Paris"}, nil
+		}
+	case "time_of_day":
+		if _, err := time.Parse(timeOfDayLayout, value.String()); err != nil {
+			return &FieldError{Code: "invalid", Message: "must be a time of day such as 09:00"}, nil
+		}
+	case "after":
+		start, end := timeOf(parent.FieldByName(r.arg)), timeOf(value)
+		if !start.IsZero() && !end.IsZero() && !end.After(start) {
+			return &FieldError{Code: "invalid_range", Message: "must be after " + jsonName(parent.Type(), r.arg)}, nil
+		}
+	case "ref":
+		found, err := s.exists(ctx, r.arg, int(value.Int()))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return &FieldError{Code: "not_found", Message: fmt.Sprintf("%s %d does not exist", r.arg, value.Int())}, nil
+		}
+	default:
+		panic("validate: unknown rule " + r.name)
+	}
+	return nil, nil
+}
+
+// timeOf reads a time field, or a time of day held in a string; it is zero
+// when the value is neither.
+func timeOf(value reflect.Value) time.Time {
+	if value.Kind() == reflect.String {
+		t, _ := time.Parse(timeOfDayLayout, value.String())
+		return t
+	}
+	t, _ := value.Interface().(time.Time)
+	return t
+}
+
+func checkBound(value reflect.Value, r rule) *FieldError {
+	limit, err := strconv.ParseFloat(r.arg, 64)
+	if err != nil {
+		panic("validate: bad bound " + r.arg)
+	}
+
+	var n float64
+	isString := value.Kind() == reflect.String
+	switch {
+	case isString:
+		n = float64(utf8.RuneCountInString(value.String()))
+	case value.CanInt():
+		n = float64(value.Int())
+	case value.CanFloat():
+		n = value.Float()
+	}
+
+	if r.name == "min" && n < limit {
+		if isString {
+			return &FieldError{Code: "too_short", Message: "must be at least " + r.arg + " characters long"}
+		}
+		return &FieldError{Code: "out_of_range", Message: "must be at least " + r.arg}
+	}
+	if r.name == "max" && n > limit {
+		if isString {
+			return &FieldError{Code: "too_long", Message: "must be at most " + r.arg + " characters long"}
+		}
+		return &FieldError{Code: "out_of_range", Message: "must be at most " + r.arg}
+	}
+	return nil
+}
+
+func jsonName(t reflect.Type, field string) string {
+	f, _ := t.FieldByName(field)
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return field
+	}
+	return name
+}
+
+// exists tells whether the entity of the given kind and id is stored.
+func (s *server) exists(ctx context.Context, kind string, id int) (bool, error) {
+	var err error
+	switch kind {
+	case "salon":
+		_, err = s.salons.GetSalon(ctx, id)
+	case "coiffeur":
+		_, err = s.coiffeurs.GetCoiffeur(ctx, id)
+	case "client":
+		_, err = s.clients.GetClient(ctx, id)
+	case "creneau":
+		_, err = s.creneaux.GetCreneau(ctx, id)
+	case "service":
+		_, err = s.services.GetService(ctx, id)
+	default:
+		panic("validate: unknown reference " + kind)
+	}
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// valid validates v and, when it breaks any rule, answers r with every
+// violation and returns false.
+func (s *server) valid(w http.ResponseWriter, r *http.Request, v any, op operation, extra ...FieldError) bool {
+	fieldErrors, err := s.validate(r.Context(), v, op)
+	if err != nil {
+		internalError(w, r, err)
+		return false
+	}
+	fieldErrors = append(fieldErrors, extra...)
+	if len(fieldErrors) > 0 {
+		invalidFields(w, r, fieldErrors...)
+		return false
+	}
+	return true
+}